@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"syscall"
@@ -197,6 +198,7 @@ func (w *watches) watched() []string {
 	for k := range w.targets {
 		s = append(s, k)
 	}
+	slices.Sort(s)
 	return s
 }
 
@@ -265,8 +267,11 @@ func (f *INotify) AddWatch(path string, op Op) error {
 
 func (f *INotify) Watch(ch chan<- InotifyEvent) error {
 	buf := make([]byte, syscall.SizeofInotifyEvent<<12)
+	// leftover is the length of a partial event kept at the start of buf
+	// for the next read
+	var leftover int
 	for {
-		n, err := f.file.Read(buf)
+		n, err := f.file.Read(buf[leftover:])
 
 		if errors.Is(err, os.ErrClosed) {
 			return err
@@ -281,35 +286,38 @@ func (f *INotify) Watch(ch chan<- InotifyEvent) error {
 			return err
 		}
 
-		if n < syscall.SizeofInotifyEvent {
-			continue
-		}
+		n += leftover
 
 		var offset int
 
 		for offset <= (n - syscall.SizeofInotifyEvent) {
-			s := bytes.NewBuffer(make([]byte, 0, syscall.PathMax))
 			e := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
 
+			// never trust e.Len beyond the bytes actually read; a truncated
+			// event is completed by the next read
+			end := offset + syscall.SizeofInotifyEvent + int(e.Len)
+			if end > n {
+				if offset == 0 && end > len(buf) {
+					// cannot ever fit, drop it
+					offset = n
+				}
+				break
+			}
+
 			if e.Mask&syscall.IN_IGNORED == syscall.IN_IGNORED {
-				offset += int(syscall.SizeofInotifyEvent + e.Len)
+				offset = end
 				continue
 			}
 
-			if e.Len > 0 {
-				b := (*[syscall.PathMax]byte)(unsafe.Pointer(&buf[offset+syscall.SizeofInotifyEvent]))
-				for i := 0; i < int(e.Len); i++ {
-					if b[i] == 0 {
-						break
-					}
-					s.WriteByte(b[i])
-				}
+			name := buf[offset+syscall.SizeofInotifyEvent : end]
+			if i := bytes.IndexByte(name, 0); i >= 0 {
+				name = name[:i]
 			}
 
 			event := InotifyEvent{
 				Len:  e.Len,
 				Mask: Mask(e.Mask),
-				Name: s.String(),
+				Name: string(name),
 				Path: f.watches.getDir(e),
 				Op:   maskToOp(e.Mask),
 			}
@@ -325,7 +333,9 @@ func (f *INotify) Watch(ch chan<- InotifyEvent) error {
 				ch <- event
 			}
 
-			offset += int(syscall.SizeofInotifyEvent + e.Len)
+			offset = end
 		}
+
+		leftover = copy(buf, buf[offset:n])
 	}
 }
@@ -9,10 +9,9 @@ import (
 	"fmt"
 	"hash"
 	"io"
-	"io/fs"
 	"os"
 	"os/signal"
-	"sync"
+	"slices"
 	"syscall"
 	"time"
 
@@ -51,145 +50,113 @@ func writeFile(h hash.Hash, filename string) {
 	io.Copy(h, f)
 }
 
-func main() {
-	settings.Load()
-	if err := settings.FlagParse(); err != nil {
-		if errors.Is(err, settings.ErrShowVersion) || errors.Is(err, settings.ErrHelp) {
-			return
-		}
-		os.Exit(1)
-	}
-
-	log.Open(log.Options{})
-	defer func() {
-		if err := log.Close(); err != nil {
-			panic(err)
-		}
-	}()
+type fileSum struct {
+	size    int64
+	modTime time.Time
+	sum     []byte
+}
 
-	var ch = make(chan InotifyEvent, 1)
-	var changed = make(chan struct{}, 1)
+// fileSums remembers the content hash of files by their size and mtime, so
+// an editor saving the same bytes again does not reread them.
+type fileSums map[string]fileSum
 
-	f := NewINotify()
-	if err := f.Open(); err != nil {
-		log.Error(err)
+func (m fileSums) write(h hash.Hash, filename string) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		delete(m, filename)
 		return
 	}
-	defer f.Close()
-
-	h := sha1.New()
-	if err := f.AddWatch(settings.ConfigPath(), Remove|Rename|Create|CloseWrite); err != nil {
-		log.Error(err)
+	if v, ok := m[filename]; ok && v.size == fi.Size() && v.modTime.Equal(fi.ModTime()) {
+		write(h, v.sum)
 		return
 	}
+	s := sha1.New()
+	writeFile(s, filename)
+	v := fileSum{size: fi.Size(), modTime: fi.ModTime(), sum: s.Sum(nil)}
+	m[filename] = v
+	write(h, v.sum)
+}
 
-	if settings.Value().TLSCertificate != "" || settings.Value().TLSKey != "" {
-		if err := f.AddWatch(settings.Value().TLSCertificate, Remove|Rename|Create|CloseWrite); err != nil {
-			log.Error(err)
-			return
-		}
-		if err := f.AddWatch(settings.Value().TLSKey, Remove|Rename|Create|CloseWrite); err != nil {
-			log.Error(err)
-			return
-		}
+type reloadResult struct {
+	changed  bool
+	settings settings.Settings
+	err      error
+}
+
+// logOptions are the options of log.Open configured by v: the log goes to
+// stdout without LogFile.
+func logOptions(v *settings.Settings) log.Options {
+	if v.LogFile == "" {
+		return log.Options{}
 	}
+	return log.Options{
+		Mode:         log.File,
+		Filename:     v.LogFile,
+		Rotation:     server.LogRotation(v),
+		SyncInterval: v.LogSyncInterval.Value(),
+	}
+}
+
+// digest hashes the parsed settings, whatever the config format, and the
+// other watched files.
+func digest(f *INotify, sums fileSums) []byte {
+	h := sha1.New()
+	m, _ := settings.ReadConfigFile()
+	data, _ := json.Marshal(m)
+	write(h, data)
 
+	config := settings.ConfigCandidates()
 	for _, s := range f.Watched() {
-		if s == settings.ConfigPath() {
-			m, _ := settings.ReadConfigFile()
-			data, _ := json.Marshal(m)
-			write(h, data)
+		if slices.Contains(config, s) {
 			continue
 		}
-		writeFile(h, s)
+		sums.write(h, s)
 	}
+	return h.Sum(nil)
+}
 
-	hash := h.Sum(nil)
+// debounce sends to ch once d has passed without another call; t is the
+// timer of the previous call, if any.
+func debounce(t *time.Timer, d time.Duration, ch chan<- struct{}) *time.Timer {
+	if t != nil {
+		t.Stop()
+	}
+	return time.AfterFunc(d, func() { ch <- struct{}{} })
+}
 
-	go f.Watch(ch)
+func main() {
+	settings.Load()
+	if err := settings.FlagParse(); err != nil {
+		if errors.Is(err, settings.ErrShowVersion) || errors.Is(err, settings.ErrPrintConfig) || errors.Is(err, settings.ErrHelp) {
+			return
+		}
+		os.Exit(1)
+	}
 
-	go func() {
-		const duration = 200 * time.Millisecond
-		var ctx context.Context
-		var cancel context.CancelFunc
-
-		for range ch {
-			if cancel != nil {
-				cancel()
-			}
-
-			// debounce
-			ctx, cancel = context.WithTimeout(appCtx, duration)
-			defer cancel()
-
-			go func(ctx context.Context) {
-				time.Sleep(duration)
-				select {
-				case <-ctx.Done():
-					return
-				default:
-				}
-				changed <- struct{}{}
-			}(ctx)
+	logging := logOptions(settings.Value())
+	log.Open(logging)
+	defer func() {
+		if err := log.Close(); err != nil {
+			panic(err)
 		}
 	}()
 
-	var ctx, cancel = context.WithCancelCause(appCtx)
-	srv := make(chan context.Context, 1)
-	srv <- ctx
+	if err := settings.Value().Validate(); err != nil {
+		log.Error(fmt.Errorf("invalid settings: %w", err))
+	}
 
-	var wg = &sync.WaitGroup{}
+	go func() {
+		sig := <-terminate
+		appExit(fmt.Errorf("%w (%s)", ErrTerminated, sig))
+	}()
 
-	for {
-		select {
-		case sig := <-terminate:
-			appExit(fmt.Errorf("%w (%s)", ErrTerminated, sig))
-			wg.Wait()
-			return
-		case ctx := <-srv:
-			wg.Add(1)
-			go func(ctx context.Context) {
-				defer wg.Done()
-				server.New().Run(ctx)
-				err := context.Cause(ctx)
-				if errors.Is(err, ErrTerminated) {
-					log.Error(err)
-				} else if errors.Is(err, ErrConfigChanged) {
-					//
-				} else if err != nil {
-					log.Info("server restart because:", err.Error())
-				}
-			}(ctx)
-		case <-changed:
-			if err := settings.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
-				log.Error(err)
-			}
-			if err := settings.FlagParse(); err != nil {
-				log.Error(err)
-			}
-
-			h := sha1.New()
-			for _, s := range f.Watched() {
-				if s == settings.ConfigPath() {
-					m, _ := settings.ReadConfigFile()
-					data, _ := json.Marshal(m)
-					write(h, data)
-					continue
-				}
-				writeFile(h, s)
-			}
-			b := h.Sum(nil)
-
-			if bytes.Equal(hash, b) {
-				continue
-			}
-
-			hash = b
-
-			cancel(ErrConfigChanged)
-			ctx, cancel = context.WithCancelCause(appCtx)
-			srv <- ctx
-		}
+	sv := &supervisor{
+		flags:    settings.FlagParse,
+		debounce: 200 * time.Millisecond,
+		started:  time.Now(),
+		logging:  logging,
+	}
+	if err := sv.run(appCtx); err != nil {
+		log.Error(err)
 	}
-
 }
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type sampler struct {
+	every  uint64
+	errors bool
+	n      atomic.Uint64
+}
+
+// sample reports whether a response with the status should be logged.
+// Non-2xx responses bypass sampling unless errors is set.
+func (s *sampler) sample(status int) bool {
+	if s.every <= 1 {
+		return true
+	}
+	if !s.errors && (status < 200 || status > 299) {
+		return true
+	}
+	return s.n.Add(1)%s.every == 1
+}
+
+type accessEntry struct {
+	Time      time.Time `json:"ts"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Bytes     int       `json:"bytes"`
+	Latency   string    `json:"latency"`
+	IP        string    `json:"ip"`
+	User      string    `json:"user,omitempty"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Aborted   bool      `json:"aborted,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+
+	request string
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// appendCLF appends e in the common log format, followed by the referer and
+// user agent for the combined one.
+func (e *accessEntry) appendCLF(b []byte, combined bool) []byte {
+	b = append(b, e.IP...)
+	b = append(b, " - "...)
+	b = append(b, dash(e.User)...)
+	b = e.Time.AppendFormat(append(b, " ["...), "02/Jan/2006:15:04:05 -0700")
+	b = strconv.AppendQuote(append(b, "] "...), e.request)
+	b = strconv.AppendInt(append(b, ' '), int64(e.Status), 10)
+	b = append(b, ' ')
+	if e.Bytes > 0 {
+		b = strconv.AppendInt(b, int64(e.Bytes), 10)
+	} else {
+		b = append(b, '-')
+	}
+	if combined {
+		b = strconv.AppendQuote(append(b, ' '), dash(e.Referer))
+		b = strconv.AppendQuote(append(b, ' '), dash(e.UserAgent))
+	}
+	return b
+}
+
+func (s *Server) accessLog() gin.HandlerFunc {
+	sp := &sampler{errors: s.settings.AccessLogSampleErrors}
+	if n := s.settings.AccessLogSample; n > 0 {
+		sp.every = uint64(n)
+	}
+	format := s.settings.AccessLogFormat
+
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		status := c.Writer.Status()
+		if !sp.sample(status) {
+			return
+		}
+
+		e := &accessEntry{
+			Time:      start,
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.Path,
+			Status:    status,
+			Bytes:     c.Writer.Size(),
+			Latency:   time.Since(start).String(),
+			IP:        c.ClientIP(),
+			User:      c.GetString(gin.AuthUserKey),
+			Referer:   c.Request.Referer(),
+			UserAgent: c.Request.UserAgent(),
+			Aborted:   c.Request.Context().Err() != nil,
+			RequestID: c.GetString(requestIDKey),
+			request:   c.Request.Method + " " + c.Request.RequestURI + " " + c.Request.Proto,
+		}
+
+		var b []byte
+		switch format {
+		case "common", "combined":
+			b = e.appendCLF(nil, format == "combined")
+		default:
+			if s.accessFile == nil {
+				fields := []any{
+					"method", e.Method,
+					"path", e.Path,
+					"status", e.Status,
+					"bytes", e.Bytes,
+					"latency", e.Latency,
+					"ip", e.IP,
+					"request_id", e.RequestID,
+				}
+				if e.Aborted {
+					fields = append(fields, "aborted", true)
+				}
+				s.logger.Infow("access", fields...)
+				return
+			}
+			b, _ = json.Marshal(e)
+		}
+
+		if s.accessFile == nil {
+			s.logger.Info(string(b))
+			return
+		}
+		if _, err := s.accessFile.Write(append(b, '\n')); err != nil {
+			s.logError(c, err)
+		}
+	}
+}
+
+// slowRequest warns about requests whose handlers run longer than threshold,
+// independent of the access log and its sampling.
+func (s *Server) slowRequest(threshold time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		// a client that went away is not a slow handler
+		if c.Request.Context().Err() != nil {
+			return
+		}
+
+		if d := time.Since(start); d > threshold {
+			s.logger.Warnw("slow request",
+				"method", c.Request.Method,
+				"path", c.Request.URL.Path,
+				"status", c.Writer.Status(),
+				"duration", d.String(),
+				"request_id", c.GetString(requestIDKey),
+			)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"path/filepath"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeManager returns the manager obtaining certificates for ACMEDomains,
+// or nil when none are configured. Certificates are cached in the data
+// directory so that restarts do not request them again.
+func (s *Server) acmeManager() *autocert.Manager {
+	if len(s.settings.ACMEDomains) == 0 {
+		return nil
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(filepath.Join(s.settings.DataDirectory, "acme")),
+		HostPolicy: autocert.HostWhitelist(s.settings.ACMEDomains...),
+		Email:      s.settings.ACMEEmail,
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"serv/zok/compress"
+)
+
+// autoIndex returns a handler listing the directory name of fsys as HTML,
+// like http.FileServer does. Names starting with a dot are left out when
+// hideDot is set.
+func (s *Server) autoIndex(fsys fs.FS, hideDot bool) func(c *gin.Context, name string) {
+	return func(c *gin.Context, name string) {
+		entries, err := fs.ReadDir(fsys, name)
+		if err != nil {
+			if !clientGone(c.Request, err) {
+				s.logError(c, err)
+			}
+			c.Status(http.StatusInternalServerError)
+			c.Abort()
+			return
+		}
+
+		var b bytes.Buffer
+		b.WriteString("<!DOCTYPE html>\n<meta charset=\"utf-8\">\n<pre>\n")
+		if name != "." {
+			b.WriteString("<a href=\"../\">../</a>\n")
+		}
+		for _, e := range entries {
+			n := e.Name()
+			if hideDot && strings.HasPrefix(n, ".") {
+				continue
+			}
+			if e.IsDir() {
+				n += "/"
+			}
+			u := url.URL{Path: n}
+			fmt.Fprintf(&b, "<a href=\"%s\">%s</a>\n", html.EscapeString(u.String()), html.EscapeString(n))
+		}
+		b.WriteString("</pre>\n")
+
+		defer compress.CompressResponseWriter(c, s.compression).Close()
+		c.Header("Cache-Control", "max-age=0")
+		c.Data(http.StatusOK, "text/html; charset=utf-8", b.Bytes())
+		c.Abort()
+	}
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// backoff computes exponentially growing retry delays with equal jitter, so
+// that instances restarted together do not retry in lockstep.
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	attempt int
+	// rand returns a number in [0, 1).
+	rand func() float64
+}
+
+func (b *backoff) next() time.Duration {
+	d := b.max
+	if b.attempt < 32 {
+		if v := b.min << b.attempt; v > 0 && v < b.max {
+			d = v
+		}
+	}
+	b.attempt++
+	half := d / 2
+	return half + time.Duration(b.rand()*float64(d-half))
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
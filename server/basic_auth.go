@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrCredentials = errors.New("invalid credentials")
+	ErrHashScheme  = errors.New("unsupported password hash, use bcrypt (htpasswd -B)")
+)
+
+// bcryptHash reports whether password is a bcrypt hash.
+func bcryptHash(password string) bool {
+	return strings.HasPrefix(password, "$2a$") || strings.HasPrefix(password, "$2b$") || strings.HasPrefix(password, "$2y$")
+}
+
+// unsupportedHash reports whether password looks like a hash of a scheme
+// other than bcrypt: $apr1$, the htpasswd default, and the other $id$
+// crypt schemes, {SHA} and the 13 characters of DES crypt.
+func unsupportedHash(password string) bool {
+	if bcryptHash(password) {
+		return false
+	}
+	if strings.HasPrefix(password, "$") || strings.HasPrefix(password, "{SHA}") {
+		return true
+	}
+	if len(password) != 13 {
+		return false
+	}
+	for _, ch := range password {
+		if !(ch == '.' || ch == '/' || '0' <= ch && ch <= '9' || 'A' <= ch && ch <= 'Z' || 'a' <= ch && ch <= 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// loadHtpasswd reads user:password lines. Only bcrypt hashes, as written by
+// htpasswd -B, and plain text passwords are supported; the users of other
+// hashes are left out and returned in refused, so they cannot log in with
+// either the password or the hash.
+func loadHtpasswd(name string) (users map[string]string, refused []error, err error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	users = map[string]string{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, password, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		if unsupportedHash(password) {
+			refused = append(refused, fmt.Errorf("%s:%d: user %q: %w", name, n, user, ErrHashScheme))
+			continue
+		}
+		users[user] = password
+	}
+	return users, refused, sc.Err()
+}
+
+func checkPassword(want, got string) bool {
+	if bcryptHash(want) {
+		return bcrypt.CompareHashAndPassword([]byte(want), []byte(got)) == nil
+	}
+	// hashing first keeps the comparison from leaking the length
+	a, b := sha256.Sum256([]byte(want)), sha256.Sum256([]byte(got))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// basicAuth returns the middleware requiring the credentials of
+// BasicAuthUser or BasicAuthFile, or nil when neither is set. An unreadable
+// BasicAuthFile refuses everyone.
+func (s *Server) basicAuth() gin.HandlerFunc {
+	users := map[string]string{}
+	if name := s.settings.BasicAuthFile; name != "" {
+		v, refused, err := loadHtpasswd(name)
+		for _, err := range refused {
+			s.logger.Warn("basic auth:", err, "(the user is refused)")
+		}
+		if err != nil {
+			s.logger.Error(fmt.Errorf("basic auth: %w (every request is refused)", err))
+			return func(c *gin.Context) {
+				Abort401(c, ErrCredentials)
+			}
+		}
+		users = v
+	}
+	if s.settings.BasicAuthUser != "" {
+		users[s.settings.BasicAuthUser] = s.settings.BasicAuthPassword
+	}
+	if len(users) == 0 {
+		return nil
+	}
+	corsEnabled := len(s.settings.CORSAllowedOrigins) > 0
+
+	return func(c *gin.Context) {
+		// browsers send preflight requests without credentials; cors
+		// answers those of the API without running any handler
+		if corsEnabled && preflight(c.Request) && isAPIPath(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		user, password, ok := c.Request.BasicAuth()
+		if ok {
+			want, found := users[user]
+			// compare anyway, unknown users take as long as wrong passwords
+			if checkPassword(want, password) && found {
+				c.Set(gin.AuthUserKey, user)
+				c.Next()
+				return
+			}
+		}
+		c.Header("WWW-Authenticate", `Basic realm="serv", charset="UTF-8"`)
+		Abort401(c, ErrCredentials)
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"serv/settings"
+)
+
+func writeHtpasswd(t *testing.T) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), ".htpasswd")
+	data := "# users\n" +
+		"alice:" + string(hash) + "\n" +
+		"bob:plain-secret\n" +
+		// hashes of htpasswd -m, -s and -d
+		"apr:$apr1$9KpQYn3H$R5PK3b3dtE8/9u0yQpGNU.\n" +
+		"sha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n" +
+		"des:rqXexS6ZhobKA\n"
+	if err := os.WriteFile(name, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoadHtpasswd(t *testing.T) {
+	users, refused, err := loadHtpasswd(writeHtpasswd(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []string{"alice", "bob"} {
+		if _, ok := users[user]; !ok {
+			t.Errorf("user %s is missing", user)
+		}
+	}
+	for _, user := range []string{"apr", "sha", "des"} {
+		if _, ok := users[user]; ok {
+			t.Errorf("user %s of an unsupported hash is loaded", user)
+		}
+	}
+	if len(refused) != 3 {
+		t.Fatalf("refused = %v, want 3 users", refused)
+	}
+	for _, err := range refused {
+		if !errors.Is(err, ErrHashScheme) {
+			t.Errorf("%v, want ErrHashScheme", err)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	htpasswd := writeHtpasswd(t)
+	auth := func(user, password string) http.Header {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth(user, password)
+		return http.Header{"Authorization": r.Header["Authorization"]}
+	}
+	preflight := http.Header{
+		"Origin":                        {"https://app.example.com"},
+		"Access-Control-Request-Method": {"POST"},
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header http.Header
+		all    int // status with basic_auth_scope all
+		api    int // status with basic_auth_scope api
+	}{
+		{"bcrypt user", http.MethodGet, "/vapi/version", auth("alice", "bcrypt-secret"), 200, 200},
+		{"plain text user", http.MethodGet, "/vapi/version", auth("bob", "plain-secret"), 200, 200},
+		{"settings user", http.MethodGet, "/vapi/version", auth("carol", "carol-secret"), 200, 200},
+		{"wrong password", http.MethodGet, "/vapi/version", auth("alice", "plain-secret"), 401, 401},
+		{"unknown user", http.MethodGet, "/vapi/version", auth("mallory", "plain-secret"), 401, 401},
+		{"no credentials", http.MethodGet, "/vapi/version", nil, 401, 401},
+		{"apr1 user with the password", http.MethodGet, "/vapi/version", auth("apr", "secret"), 401, 401},
+		{"apr1 user with the hash", http.MethodGet, "/vapi/version", auth("apr", "$apr1$9KpQYn3H$R5PK3b3dtE8/9u0yQpGNU."), 401, 401},
+		{"SHA user with the hash", http.MethodGet, "/vapi/version", auth("sha", "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="), 401, 401},
+		{"DES user with the hash", http.MethodGet, "/vapi/version", auth("des", "rqXexS6ZhobKA"), 401, 401},
+		{"static file", http.MethodGet, "/app.js", nil, 401, 200},
+		{"static file with credentials", http.MethodGet, "/app.js", auth("bob", "plain-secret"), 200, 200},
+		{"probe", http.MethodGet, "/healthz", nil, 200, 200},
+		{"API preflight", http.MethodOptions, "/vapi/reload", preflight, 204, 204},
+		{"static preflight", http.MethodOptions, "/app.js", preflight, 401, 405},
+	}
+	for _, scope := range []string{"all", "api"} {
+		h := testHandler(t, testFiles(), func(v *settings.Settings) {
+			v.BasicAuthFile = htpasswd
+			v.BasicAuthUser, v.BasicAuthPassword = "carol", "carol-secret"
+			v.BasicAuthScope = scope
+			v.CORSAllowedOrigins = []string{"https://app.example.com"}
+		})
+		for _, tt := range tests {
+			want := tt.all
+			if scope == "api" {
+				want = tt.api
+			}
+			w := do(h, tt.method, tt.target, tt.header)
+			if w.Code != want {
+				t.Errorf("scope %s, %s: %s %s = %d, want %d", scope, tt.name, tt.method, tt.target, w.Code, want)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("scope %s, %s: 401 without WWW-Authenticate", scope, tt.name)
+			}
+		}
+	}
+}
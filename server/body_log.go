@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// limitedBuffer keeps the first max bytes written to it and accepts, but
+// drops, the rest.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if n := b.max - b.buf.Len(); n < len(p) {
+		b.truncated = true
+		if n > 0 {
+			b.buf.Write(p[:n])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+type bodyLogWriter struct {
+	gin.ResponseWriter
+	body *limitedBuffer
+}
+
+func (w *bodyLogWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.body.Write(p[:n])
+	return n, err
+}
+
+func (w *bodyLogWriter) WriteString(s string) (int, error) {
+	n, err := w.ResponseWriter.WriteString(s)
+	w.body.Write([]byte(s[:n]))
+	return n, err
+}
+
+var redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+func redactHeader(h http.Header, extra []string) http.Header {
+	h = h.Clone()
+	for _, k := range append(redactedHeaders, extra...) {
+		k = http.CanonicalHeaderKey(k)
+		if _, ok := h[k]; ok {
+			h[k] = []string{"(redacted)"}
+		}
+	}
+	return h
+}
+
+// bodyLog logs up to max bytes of the request and response bodies at debug
+// level. The handler still reads the whole request body. Bodies of paths
+// below the redacted prefixes are not captured, and the values of
+// credential headers and the extra headers are replaced.
+func (s *Server) bodyLog(max int, redacted, headers []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, prefix := range redacted {
+			if strings.HasPrefix(c.Request.URL.Path, prefix) {
+				c.Next()
+				return
+			}
+		}
+
+		req := &limitedBuffer{max: max}
+		if body := c.Request.Body; body != nil && body != http.NoBody {
+			c.Request.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(body, req), body}
+		}
+		res := &limitedBuffer{max: max}
+		c.Writer = &bodyLogWriter{ResponseWriter: c.Writer, body: res}
+
+		c.Next()
+
+		s.logger.Debugw("body",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"request_header", redactHeader(c.Request.Header, headers),
+			"request", req.buf.String(),
+			"request_truncated", req.truncated,
+			"response", res.buf.String(),
+			"response_truncated", res.truncated,
+		)
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"cmp"
+	"path"
+	"slices"
+	"strings"
+)
+
+type cacheRule struct {
+	pattern string
+	value   string
+}
+
+// cacheRules orders the CacheControl patterns by precedence: the longest
+// pattern first, equally long ones alphabetically.
+func cacheRules(patterns map[string]string) []cacheRule {
+	rules := make([]cacheRule, 0, len(patterns))
+	for pattern, value := range patterns {
+		rules = append(rules, cacheRule{pattern, value})
+	}
+	slices.SortFunc(rules, func(a, b cacheRule) int {
+		if n := cmp.Compare(len(b.pattern), len(a.pattern)); n != 0 {
+			return n
+		}
+		return strings.Compare(a.pattern, b.pattern)
+	})
+	return rules
+}
+
+// matchCacheControl returns the Cache-Control of the first rule matching
+// the file name. Patterns with a slash match the whole path from the web
+// root, like /assets/*.js, others the base name only, like *.html.
+func matchCacheControl(rules []cacheRule, name string) (string, bool) {
+	p := "/" + name
+	for _, r := range rules {
+		target := path.Base(p)
+		if strings.Contains(r.pattern, "/") {
+			target = p
+		}
+		if ok, _ := path.Match(r.pattern, target); ok {
+			return r.value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	compressForce = "force"
+	compressSkip  = "skip"
+	compressAuto  = "auto"
+
+	// compressSample is how much of a file the auto policy compresses to
+	// estimate the ratio.
+	compressSample = 64 << 10
+)
+
+type countWriter int64
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	*w += countWriter(len(p))
+	return len(p), nil
+}
+
+// fileStamp tells the versions of a file apart.
+type fileStamp struct {
+	size    int64
+	modtime time.Time
+}
+
+func stampOf(fi fs.FileInfo) fileStamp {
+	return fileStamp{size: fi.Size(), modtime: fi.ModTime()}
+}
+
+func (s fileStamp) equal(o fileStamp) bool {
+	return s.size == o.size && s.modtime.Equal(o.modtime)
+}
+
+type sampled struct {
+	stamp    fileStamp
+	compress bool
+}
+
+// compressPolicy decides per file extension whether static files are
+// compressed. Auto decisions are remembered until the file changes.
+type compressPolicy struct {
+	policies map[string]string
+	ratio    float64
+	// sampled holds the decision for the current version of each file
+	sampled sync.Map // name -> sampled
+}
+
+func newCompressPolicy(policies map[string]string, ratio float64) *compressPolicy {
+	p := &compressPolicy{policies: map[string]string{}, ratio: ratio}
+	for ext, v := range policies {
+		p.policies["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = strings.ToLower(v)
+	}
+	if p.ratio <= 0 {
+		p.ratio = 0.9
+	}
+	return p
+}
+
+// compress reports whether the file name should be compressed.
+func (p *compressPolicy) compress(fsys fs.FS, name string, fi fs.FileInfo) bool {
+	switch p.policies[strings.ToLower(path.Ext(name))] {
+	case compressSkip:
+		return false
+	case compressAuto:
+	default:
+		return true
+	}
+
+	stamp := stampOf(fi)
+	if v, ok := p.sampled.Load(name); ok && v.(sampled).stamp.equal(stamp) {
+		return v.(sampled).compress
+	}
+	ok := p.sample(fsys, name)
+	p.sampled.Store(name, sampled{stamp: stamp, compress: ok})
+	return ok
+}
+
+func (p *compressPolicy) sample(fsys fs.FS, name string) bool {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+
+	var out countWriter
+	gz, _ := gzip.NewWriterLevel(&out, gzip.BestSpeed)
+	n, err := io.Copy(gz, io.LimitReader(f, compressSample))
+	if err != nil || n == 0 {
+		return err != nil
+	}
+	gz.Close()
+	return float64(out) <= float64(n)*p.ratio
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// etagMatch reports whether etag weakly matches one of the entity tags
+// listed in an If-None-Match header value.
+func etagMatch(list string, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// strongMatch reports whether etag strongly matches one of the entity tags
+// listed in an If-Match header value; weak tags never match a listed tag,
+// but * matches any current representation.
+func strongMatch(list string, etag string) bool {
+	weak := etag == "" || strings.HasPrefix(etag, "W/")
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || !weak && v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// preconditionFailed evaluates If-Match, or If-Unmodified-Since in its
+// absence, which RFC 7232 section 6 checks before If-None-Match.
+func preconditionFailed(r *http.Request, etag string, modtime time.Time) bool {
+	if im := r.Header.Get("If-Match"); im != "" {
+		return !strongMatch(im, etag)
+	}
+
+	ius := r.Header.Get("If-Unmodified-Since")
+	if ius == "" || modtime.IsZero() || modtime.Unix() <= 0 {
+		return false
+	}
+	t, err := http.ParseTime(ius)
+	if err != nil {
+		return false
+	}
+	return modtime.Truncate(time.Second).After(t)
+}
+
+// notModified evaluates the conditional GET headers in the order of RFC 7232
+// section 6: when If-None-Match is present, If-Modified-Since is ignored.
+func notModified(r *http.Request, etag string, modtime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatch(inm, etag)
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modtime.IsZero() || modtime.Unix() <= 0 {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	// HTTP dates have a granularity of one second
+	return !modtime.Truncate(time.Second).After(t)
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"serv/settings"
+)
+
+var testModTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// testHandler builds the http handler of a server serving fsys with the
+// default settings, as changed by edit.
+func testHandler(t *testing.T, fsys fs.FS, edit func(*settings.Settings)) http.Handler {
+	t.Helper()
+	v := *settings.Value()
+	if edit != nil {
+		edit(&v)
+	}
+	s := New(ServerConfig{Settings: v, FS: fsys})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := s.init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return s.handler
+}
+
+func testFiles() fstest.MapFS {
+	return fstest.MapFS{
+		"index.html": {Data: []byte("<h1>index</h1>"), ModTime: testModTime},
+		"app.js":     {Data: []byte("console.log('serv')"), ModTime: testModTime},
+	}
+}
+
+func do(h http.Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// validators returns the ETag and Last-Modified of an unconditional GET of
+// a browser.
+func validators(t *testing.T, h http.Handler, target string) (etag, lastModified string) {
+	t.Helper()
+	w := do(h, http.MethodGet, target, http.Header{"Accept": {"text/html"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d, want 200", target, w.Code)
+	}
+	etag, lastModified = w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("GET %s: ETag %q, Last-Modified %q", target, etag, lastModified)
+	}
+	return
+}
+
+func TestConditionalGETPrecedence(t *testing.T) {
+	h := testHandler(t, testFiles(), nil)
+	etag, lastModified := validators(t, h, "/app.js")
+	before := testModTime.Add(-time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"both match", http.Header{"If-None-Match": {etag}, "If-Modified-Since": {lastModified}}, http.StatusNotModified},
+		{"etag mismatch, date match", http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {lastModified}}, http.StatusOK},
+		{"etag match, date older", http.Header{"If-None-Match": {etag}, "If-Modified-Since": {before}}, http.StatusNotModified},
+		{"only date, not modified", http.Header{"If-Modified-Since": {lastModified}}, http.StatusNotModified},
+		{"only date, modified", http.Header{"If-Modified-Since": {before}}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do(h, http.MethodGet, "/app.js", tt.header); w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	const etag = `"abc"`
+	date := testModTime.Format(http.TimeFormat)
+	tests := []struct {
+		method string
+		header http.Header
+		want   bool
+	}{
+		{http.MethodGet, http.Header{"If-None-Match": {etag}, "If-Modified-Since": {date}}, true},
+		{http.MethodGet, http.Header{"If-None-Match": {`"xyz"`}, "If-Modified-Since": {date}}, false},
+		{http.MethodGet, http.Header{"If-Modified-Since": {date}}, true},
+		{http.MethodGet, http.Header{"If-Modified-Since": {"not a date"}}, false},
+		{http.MethodHead, http.Header{"If-None-Match": {etag}}, true},
+		{http.MethodPost, http.Header{"If-None-Match": {etag}}, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/", nil)
+		r.Header = tt.header
+		if got := notModified(r, etag, testModTime); got != tt.want {
+			t.Errorf("%s %v: notModified = %v, want %v", tt.method, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestConditionalValidators(t *testing.T) {
+	h := testHandler(t, testFiles(), nil)
+	// / is the index of the web root, /app/route the SPA fallback
+	for _, target := range []string{"/app.js", "/", "/app/route"} {
+		etag, _ := validators(t, h, target)
+		tests := []struct {
+			name   string
+			header http.Header
+			want   int
+		}{
+			{"If-None-Match match", http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+			{"If-None-Match weak match", http.Header{"If-None-Match": {"W/" + etag}}, http.StatusNotModified},
+			{"If-None-Match in a list", http.Header{"If-None-Match": {`"a", ` + etag + `, "b"`}}, http.StatusNotModified},
+			{"If-None-Match *", http.Header{"If-None-Match": {"*"}}, http.StatusNotModified},
+			{"If-None-Match mismatch", http.Header{"If-None-Match": {`"a", "b"`}}, http.StatusOK},
+			{"If-Match match", http.Header{"If-Match": {etag}}, http.StatusOK},
+			{"If-Match *", http.Header{"If-Match": {"*"}}, http.StatusOK},
+			{"If-Match mismatch", http.Header{"If-Match": {`"a"`}}, http.StatusPreconditionFailed},
+			{"If-Match mismatch, If-None-Match match", http.Header{"If-Match": {`"a"`}, "If-None-Match": {etag}}, http.StatusPreconditionFailed},
+		}
+		for _, tt := range tests {
+			tt.header.Set("Accept", "text/html")
+			if w := do(h, http.MethodGet, target, tt.header); w.Code != tt.want {
+				t.Errorf("GET %s, %s: status = %d, want %d", target, tt.name, w.Code, tt.want)
+			}
+		}
+	}
+}
+
+func TestPreconditionFailed(t *testing.T) {
+	const etag = `"abc"`
+	tests := []struct {
+		etag   string
+		header http.Header
+		want   bool
+	}{
+		{etag, http.Header{}, false},
+		{etag, http.Header{"If-Match": {etag}}, false},
+		{etag, http.Header{"If-Match": {`"x", "abc"`}}, false},
+		{etag, http.Header{"If-Match": {"*"}}, false},
+		{etag, http.Header{"If-Match": {`"x"`}}, true},
+		// If-Match uses the strong comparison
+		{etag, http.Header{"If-Match": {`W/"abc"`}}, true},
+		{`W/"abc"`, http.Header{"If-Match": {`W/"abc"`}}, true},
+		{`W/"abc"`, http.Header{"If-Match": {"*"}}, false},
+		{"", http.Header{"If-Match": {"*"}}, false},
+		{"", http.Header{"If-Match": {`""`}}, true},
+		{etag, http.Header{"If-Unmodified-Since": {testModTime.Format(http.TimeFormat)}}, false},
+		{etag, http.Header{"If-Unmodified-Since": {testModTime.Add(-time.Hour).Format(http.TimeFormat)}}, true},
+		// If-Unmodified-Since is ignored with If-Match
+		{etag, http.Header{"If-Match": {etag}, "If-Unmodified-Since": {testModTime.Add(-time.Hour).Format(http.TimeFormat)}}, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header = tt.header
+		if got := preconditionFailed(r, tt.etag, testModTime); got != tt.want {
+			t.Errorf("%s %v: preconditionFailed = %v, want %v", tt.etag, tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// preflight reports whether the request is a CORS preflight request.
+func preflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// cors answers cross-origin requests from the allowed origins, * allowing
+// any, and preflight requests for them.
+func cors(origins, methods, headers []string, credentials bool) gin.HandlerFunc {
+	anyOrigin := slices.Contains(origins, "*")
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(headers, ", ")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		listed := slices.Contains(origins, origin)
+		if !anyOrigin && !listed {
+			c.Next()
+			return
+		}
+
+		// credentials are only allowed for origins listed by name, never
+		// for the wildcard
+		switch {
+		case listed && credentials:
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Access-Control-Allow-Credentials", "true")
+		case anyOrigin:
+			c.Header("Access-Control-Allow-Origin", "*")
+		default:
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+
+		if preflight(c.Request) {
+			c.Header("Access-Control-Allow-Methods", allowMethods)
+			if allowHeaders != "" {
+				c.Header("Access-Control-Allow-Headers", allowHeaders)
+			}
+			c.Header("Access-Control-Max-Age", "600")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+
+	"serv/zok/proc"
+)
+
+type memStats struct {
+	HeapAlloc    uint64 `json:"heap_alloc"`
+	HeapSys      uint64 `json:"heap_sys"`
+	HeapIdle     uint64 `json:"heap_idle"`
+	HeapReleased uint64 `json:"heap_released"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	Sys          uint64 `json:"sys"`
+	NumGC        uint32 `json:"num_gc"`
+	RSS          uint64 `json:"rss_kb,omitempty"` // from /proc/self/status
+}
+
+func readMemStats() memStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	v := memStats{
+		HeapAlloc:    m.HeapAlloc,
+		HeapSys:      m.HeapSys,
+		HeapIdle:     m.HeapIdle,
+		HeapReleased: m.HeapReleased,
+		HeapObjects:  m.HeapObjects,
+		Sys:          m.Sys,
+		NumGC:        m.NumGC,
+	}
+	if st, err := proc.SelfStatus(); err == nil {
+		v.RSS = st.VMRss
+	}
+	return v
+}
+
+// GC runs a garbage collection, returns memory to the OS and reports the
+// memory statistics before and after.
+func (s *Server) GC(c *gin.Context) {
+	before := readMemStats()
+	runtime.GC()
+	debug.FreeOSMemory()
+	c.JSON(http.StatusOK, gin.H{
+		"before": before,
+		"after":  readMemStats(),
+	})
+}
@@ -1,6 +1,13 @@
 package server
 
-import "serv/zok/log"
+import (
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+
+	"serv/zok/log"
+)
 
 type ServerInternalError struct {
 	err any
@@ -36,3 +43,13 @@ func (e *ServerInternalError) String() string {
 func InternalServerError(e any) *ServerInternalError {
 	return &ServerInternalError{err: e, StackTrace: log.Stack(4, 10)}
 }
+
+// clientGone reports whether err comes from writing to a client that has
+// disconnected, or came up while r was canceled by the client going away,
+// which is normal and not worth logging. r is nil outside of a request.
+func clientGone(r *http.Request, err error) bool {
+	if errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	return r != nil && r.Context().Err() != nil
+}
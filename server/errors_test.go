@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+)
+
+func TestClientGone(t *testing.T) {
+	live := httptest.NewRequest("GET", "/", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	gone := live.WithContext(ctx)
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"broken pipe", fmt.Errorf("write: %w", syscall.EPIPE), true},
+		{"reset", &net.OpError{Op: "write", Err: syscall.ECONNRESET}, true},
+		{"closed", net.ErrClosed, true},
+		{"read error", fs.ErrPermission, false},
+	}
+	for _, tt := range tests {
+		if got := clientGone(nil, tt.err); got != tt.want {
+			t.Errorf("%s without a request: %v, want %v", tt.name, got, tt.want)
+		}
+		if got := clientGone(live, tt.err); got != tt.want {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+		if !clientGone(gone, tt.err) {
+			t.Errorf("%s of a canceled request: false, want true", tt.name)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"sync"
+)
+
+// etags computes the ETags of static files. Content hashes are remembered
+// until the size or modification time of the file changes; weak ETags are
+// made of those two alone and never read the file.
+type etags struct {
+	weak bool
+	// hashed holds the ETag of the current version of each file
+	hashed sync.Map // name -> hashedETag
+}
+
+type hashedETag struct {
+	stamp fileStamp
+	etag  string
+}
+
+func (e *etags) get(fsys fs.FS, name string, fi fs.FileInfo) (string, error) {
+	if e.weak {
+		return fmt.Sprintf(`W/"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()), nil
+	}
+	stamp := stampOf(fi)
+	if v, ok := e.hashed.Load(name); ok && v.(hashedETag).stamp.equal(stamp) {
+		return v.(hashedETag).etag, nil
+	}
+	v, err := etag(fsys, name)
+	if err != nil {
+		return "", err
+	}
+	e.hashed.Store(name, hashedETag{stamp: stamp, etag: v})
+	return v, nil
+}
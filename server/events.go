@@ -0,0 +1,37 @@
+package server
+
+import "time"
+
+type EventKind string
+
+const (
+	EventListening    EventKind = "listening"
+	EventListenFailed EventKind = "listen-failed"
+	EventReloading    EventKind = "reloading"
+	EventShuttingDown EventKind = "shutting-down"
+)
+
+// Event is a lifecycle transition of a Server.
+type Event struct {
+	Kind EventKind
+	// Listener is http, https or admin; empty for events of the whole server.
+	Listener string
+	Addr     string
+	Err      error
+	Time     time.Time
+}
+
+// Emit sends e to events without blocking; the event is dropped when the
+// consumer is not keeping up.
+func Emit(events chan<- Event, e Event) {
+	if events == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case events <- e:
+	default:
+	}
+}
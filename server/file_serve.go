@@ -3,23 +3,26 @@ package server
 import (
 	"crypto/md5"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
-	"serv/settings"
 	"serv/zok/compress"
 	"serv/zok/header"
 )
 
-func etag(filename string) (string, error) {
+func etag(fsys fs.FS, name string) (string, error) {
 	h := md5.New()
-	f, err := os.Open(filename)
+	f, err := fsys.Open(name)
 	if err != nil {
 		return "", err
 	}
@@ -31,73 +34,405 @@ func etag(filename string) (string, error) {
 	return strconv.Quote(base64.StdEncoding.EncodeToString(h.Sum(nil))), nil
 }
 
-func fileExists(root, urlpath string) bool {
-	if filename := strings.TrimPrefix(urlpath, "/"); len(filename) < len(urlpath) {
-		name := filepath.Join(root, filename)
-		stats, err := os.Stat(name)
+// fsName converts a URL path to a name in the web root file system.
+func fsName(urlpath string) (string, bool) {
+	if !strings.HasPrefix(urlpath, "/") {
+		return "", false
+	}
+	name := strings.TrimPrefix(path.Clean(urlpath), "/")
+	if name == "" {
+		name = "."
+	}
+	return name, fs.ValidPath(name)
+}
+
+// checkWebRoot reports an error when fsys is not a directory or holds no
+// regular file, which would make every request 404.
+func checkWebRoot(fsys fs.FS) error {
+	fi, err := fs.Stat(fsys, ".")
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return errors.New("web root is not a directory")
+	}
+	found := false
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
 		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			found = true
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("web root contains no files")
+	}
+	return nil
+}
+
+// dotfile reports whether a component of the clean name starts with a dot
+// and the path is not below one of the allowed prefixes.
+func dotfile(name string, allow []string) bool {
+	if name == "." {
+		return false
+	}
+	p := "/" + name + "/"
+	for _, prefix := range allow {
+		if strings.HasPrefix(p, prefix) {
 			return false
 		}
-		return stats.Mode().IsRegular()
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") {
+			return true
+		}
 	}
 	return false
 }
 
-func (s *Server) returnIndex(useAny bool) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		index := filepath.Join(settings.Value().DataDirectory, settings.Value().WebRoot, "index.html")
-		_, err := os.Stat(index)
-		if err != nil {
-			return
+func setLastModified(c *gin.Context, modtime time.Time) {
+	if !modtime.IsZero() && modtime.Unix() > 0 {
+		c.Header("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+	}
+}
+
+type indexRule struct {
+	prefix string
+	file   string
+}
+
+// indexRules returns the configured index documents, most specific prefix
+// first. The root prefix falls back to IndexFile.
+func (s *Server) indexRules() []indexRule {
+	rules := []indexRule{}
+	root := false
+	for prefix, file := range s.settings.IndexFiles {
+		if !strings.HasPrefix(prefix, "/") || file == "" {
+			continue
+		}
+		if !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		root = root || prefix == "/"
+		rules = append(rules, indexRule{prefix: prefix, file: file})
+	}
+	if !root {
+		file := s.settings.IndexFile
+		if file == "" {
+			file = "index.html"
 		}
+		rules = append(rules, indexRule{prefix: "/", file: file})
+	}
+	slices.SortFunc(rules, func(a, b indexRule) int {
+		return len(b.prefix) - len(a.prefix)
+	})
+	return rules
+}
 
-		a := header.ParseAccept(c.Request.Header.Get("Accept"))
+func matchIndex(rules []indexRule, urlpath string) indexRule {
+	if !strings.HasSuffix(urlpath, "/") {
+		urlpath += "/"
+	}
+	for _, r := range rules {
+		if strings.HasPrefix(urlpath, r.prefix) {
+			return r
+		}
+	}
+	// the root rule is last and matches every path
+	return rules[len(rules)-1]
+}
 
-		if a.Contains("text/html") || (useAny && a.Contains("*/*")) {
-			eTag, _ := etag(index)
-			im := c.Request.Header.Get("If-Match")
-			if im != "" && im == eTag {
-				c.Status(http.StatusNotModified)
-				return
+// serveFile returns a function writing the regular file name with its
+// validators, handling conditional requests and compression.
+func (s *Server) serveFile(fsys fs.FS, errorPage func(*gin.Context, int)) func(c *gin.Context, name string, fi fs.FileInfo, cacheControl string) {
+	negotiate := s.settings.NegotiateLanguage
+	sidecar := s.settings.Precompressed
+	cache := cacheRules(s.settings.CacheControl)
+	return func(c *gin.Context, name string, fi fs.FileInfo, cacheControl string) {
+		if v, ok := matchCacheControl(cache, name); ok {
+			cacheControl = v
+		}
+
+		if negotiate {
+			c.Writer.Header().Add("Vary", "Accept-Language")
+			if v, vfi, lang := languageVariant(fsys, name, c.GetHeader("Accept-Language")); v != "" {
+				name, fi = v, vfi
+				c.Header("Content-Language", lang)
 			}
+		}
 
-			if eTag != "" {
-				c.Header("Cache-Control", "max-age=0, private, must-revalidate")
-				c.Header("Etag", eTag)
+		// the ETag below is that of the compressed file, which is the
+		// representation sent
+		encoding := ""
+		if sidecar {
+			c.Writer.Header().Add("Vary", "Accept-Encoding")
+			if v, vfi, enc := precompressed(fsys, name, c.GetHeader("Accept-Encoding")); v != "" {
+				if ctype := contentType(fsys, name); ctype != "" {
+					c.Header("Content-Type", ctype)
+				}
+				name, fi, encoding = v, vfi, enc
 			}
+		}
 
-			defer compress.CompressResponseWriter(c).Close()
+		eTag, err := s.etags.get(fsys, name, fi)
+		if err != nil {
+			// the file exists but cannot be read
+			if !clientGone(c.Request, err) {
+				s.logError(c, err)
+			}
+			errorPage(c, http.StatusInternalServerError)
+			return
+		}
+		if eTag != "" {
+			c.Header("Cache-Control", cacheControl)
+			c.Header("Etag", eTag)
+		}
 
-			c.File(index)
+		if preconditionFailed(c.Request, eTag, fi.ModTime()) {
+			c.Status(http.StatusPreconditionFailed)
 			c.Abort()
+			return
+		}
+
+		if notModified(c.Request, eTag, fi.ModTime()) {
+			setLastModified(c, fi.ModTime())
+			c.Status(http.StatusNotModified)
+			c.Abort()
+			return
+		}
+
+		// ServeFileFS advertises Accept-Ranges: bytes, which the compressing
+		// writer turns into none when it encodes the body. A client refusing
+		// identity gets even the files the policy skips compressed.
+		if encoding != "" {
+			c.Header("Content-Encoding", encoding)
+			if s.compression.Debug {
+				c.Header(compress.DebugHeader, "precompressed")
+			}
+		} else if s.policy.compress(fsys, name, fi) || header.ParseAcceptEncoding(c.GetHeader("Accept-Encoding")).IdentityRefused() {
+			defer compress.CompressResponseWriter(c, s.compression).Close()
+		} else if s.compression.Debug {
+			c.Header(compress.DebugHeader, "skipped-policy")
+		}
+
+		http.ServeFileFS(c.Writer, c.Request, fsys, name)
+		c.Abort()
+	}
+}
+
+func (s *Server) returnIndex(fsys fs.FS, rules []indexRule, serveFile func(*gin.Context, string, fs.FileInfo, string)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a := header.ParseAccept(c.Request.Header.Get("Accept"))
+
+		// programmatic clients get a JSON error instead of an HTML page
+		if a.Best("text/html", "application/json") == "application/json" {
+			Abort404(c, nil)
+			return
 		}
+
+		r := matchIndex(rules, c.Request.URL.Path)
+		dir, _ := fsName(r.prefix)
+		index := path.Join(dir, r.file)
+
+		fi, err := fs.Stat(fsys, index)
+		if err != nil || !fi.Mode().IsRegular() {
+			return
+		}
+
+		// text/* and */* accept it too, text/html;q=0 does not
+		if a.Quality("text/html") > 0 {
+			serveFile(c, index, fi, "max-age=0, private, must-revalidate")
+		}
+	}
+}
+
+const defaultErrorPage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>%[1]d %[2]s</title></head>
+<body><h1>%[1]d %[2]s</h1></body></html>
+`
+
+// errorPage returns a handler writing the HTML error document for 5xx
+// responses of static files, falling back to a built-in page.
+func (s *Server) errorPage(fsys fs.FS) func(c *gin.Context, code int) {
+	var page []byte
+	if name := s.settings.ErrorPage; name != "" {
+		if n, ok := fsName("/" + strings.TrimPrefix(name, "/")); ok {
+			page, _ = fs.ReadFile(fsys, n)
+		}
+	}
+
+	return func(c *gin.Context, code int) {
+		body := page
+		if body == nil {
+			body = []byte(fmt.Sprintf(defaultErrorPage, code, http.StatusText(code)))
+		}
+		c.Header("Cache-Control", "no-store")
+		c.Data(code, "text/html; charset=utf-8", body)
+		c.Abort()
+	}
+}
+
+// notFoundPage returns a handler writing the NotFoundPage document of the
+// web root with status 404. Without one, nothing is written and the default
+// 404 response remains.
+func (s *Server) notFoundPage(fsys fs.FS) gin.HandlerFunc {
+	var page []byte
+	if name := s.settings.NotFoundPage; name != "" {
+		if n, ok := fsName("/" + strings.TrimPrefix(name, "/")); ok {
+			page, _ = fs.ReadFile(fsys, n)
+		}
+	}
+
+	return func(c *gin.Context) {
+		if page == nil || c.IsAborted() || c.Writer.Written() {
+			return
+		}
+		defer compress.CompressResponseWriter(c, s.compression).Close()
+		c.Header("Cache-Control", "no-store")
+		c.Data(http.StatusNotFound, "text/html; charset=utf-8", page)
+		c.Abort()
+	}
+}
+
+// singleFile serves the document name for every GET and HEAD request.
+func singleFile(fsys fs.FS, name string, serveFile func(*gin.Context, string, fs.FileInfo, string)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			Abort405(c, nil, http.MethodGet, http.MethodHead)
+			return
+		}
+		fi, err := fs.Stat(fsys, name)
+		if err != nil || !fi.Mode().IsRegular() {
+			return
+		}
+		serveFile(c, name, fi, "max-age=0, private, must-revalidate")
 	}
 }
 
 func (s *Server) fileServe() gin.HandlerFunc {
-	root := filepath.Join(settings.Value().DataDirectory, settings.Value().WebRoot)
-	serve := http.StripPrefix("/", http.FileServer(gin.Dir(root, false)))
-	index := s.returnIndex(true)
+	fsys := s.fs
+	rules := s.indexRules()
+	serveFile := s.serveFile(fsys, s.errorPage(fsys))
+	if s.settings.SingleFile != "" {
+		name, _ := fsName("/" + strings.TrimPrefix(s.settings.SingleFile, "/"))
+		return singleFile(fsys, name, serveFile)
+	}
+	index := s.returnIndex(fsys, rules, serveFile)
+	robots := s.settings.RobotsTxt
+	notFound := s.settings.NotFoundPrefixes
+	denyDotfiles := s.settings.DenyDotfiles
+	dotfilesAllow := s.settings.DotfilesAllow
+	missing := s.notFoundPage(fsys)
+	spa := s.settings.SPAFallback
+	var listing func(*gin.Context, string)
+	if s.settings.AutoIndex {
+		listing = s.autoIndex(fsys, denyDotfiles)
+	}
+	rootRedirect := s.settings.RootRedirect
+	var rootDocument string
+	if s.settings.RootDocument != "" {
+		rootDocument, _ = fsName("/" + strings.TrimPrefix(s.settings.RootDocument, "/"))
+	}
 
 	return func(c *gin.Context) {
-		if fileExists(root, c.Request.URL.Path) {
-			filename := filepath.Join(settings.Value().DataDirectory, settings.Value().WebRoot, c.Request.URL.Path)
-			if eTag, _ := etag(filename); eTag != "" {
-				c.Header("Cache-Control", "max-age=0")
-				c.Header("Etag", eTag)
-			}
+		name, ok := fsName(c.Request.URL.Path)
+		if !ok {
+			return
+		}
+
+		// static files are only read
+		if !safeMethod(c.Request.Method) && !isAPIPath(c.Request.URL.Path) {
+			Abort405(c, nil, http.MethodGet, http.MethodHead)
+			return
+		}
 
-			defer compress.CompressResponseWriter(c).Close()
+		// the landing page may differ from the index of the web root
+		if c.Request.URL.Path == "/" && safeMethod(c.Request.Method) {
+			if rootRedirect != "" {
+				c.Redirect(http.StatusFound, rootRedirect)
+				c.Abort()
+				return
+			}
+			if rootDocument != "" {
+				if fi, err := fs.Stat(fsys, rootDocument); err == nil && fi.Mode().IsRegular() {
+					serveFile(c, rootDocument, fi, "max-age=0, private, must-revalidate")
+					return
+				}
+			}
+		}
 
-			serve.ServeHTTP(c.Writer, c.Request)
+		if denyDotfiles && dotfile(name, dotfilesAllow) {
+			missing(c)
 			return
 		}
 
+		if fi, err := fs.Stat(fsys, name); err == nil {
+			if fi.Mode().IsRegular() {
+				serveFile(c, name, fi, "max-age=0")
+				return
+			}
+
+			if fi.IsDir() {
+				r := matchIndex(rules, c.Request.URL.Path)
+				doc := path.Join(name, r.file)
+				docFi, err := fs.Stat(fsys, doc)
+				hasDoc := err == nil && docFi.Mode().IsRegular()
+				if hasDoc || listing != nil && safeMethod(c.Request.Method) {
+					// /docs becomes /docs/, so relative links in the index resolve
+					if !strings.HasSuffix(c.Request.URL.Path, "/") && safeMethod(c.Request.Method) {
+						u := *c.Request.URL
+						u.Path += "/"
+						c.Redirect(http.StatusMovedPermanently, u.RequestURI())
+						c.Abort()
+						return
+					}
+					if hasDoc {
+						serveFile(c, doc, docFi, "max-age=0, private, must-revalidate")
+					} else {
+						listing(c, name)
+					}
+					return
+				}
+			}
+		}
+
 		if c.Request.Method != http.MethodGet {
-			// TODO: custom not found page
+			missing(c)
+			return
+		}
+
+		// well-known crawler paths must not fall back to the SPA index
+		switch c.Request.URL.Path {
+		case "/robots.txt":
+			c.String(http.StatusOK, robots)
+			return
+		case "/sitemap.xml":
+			missing(c)
 			return
 		}
 
-		index(c)
+		// API paths are not part of the SPA, even when served by the admin listener
+		if isAPIPath(c.Request.URL.Path) {
+			return
+		}
+
+		for _, prefix := range notFound {
+			if strings.HasPrefix(c.Request.URL.Path, prefix) {
+				missing(c)
+				return
+			}
+		}
+
+		if spa {
+			index(c)
+		}
+		// no index to fall back to
+		missing(c)
 	}
 }
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"testing/fstest"
+
+	"serv/settings"
+)
+
+func TestDotfile(t *testing.T) {
+	allow := []string{"/.well-known/"}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{".", false},
+		{"index.html", false},
+		{".env", true},
+		{".git/config", true},
+		{"app/.env", true},
+		{"a/b/.htpasswd", true},
+		{"assets/.cache/app.js", true},
+		{"assets/app.min.js", false},
+		{".well-known", false},
+		{".well-known/security.txt", false},
+		{".well-known/acme-challenge/token", false},
+		{".well-known-not/x", true},
+		{"a/.well-known/x", true},
+	}
+	for _, tt := range tests {
+		if got := dotfile(tt.name, allow); got != tt.want {
+			t.Errorf("dotfile(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDenyDotfiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":                       {Data: []byte("<h1>index</h1>")},
+		".env":                             {Data: []byte("SECRET=1")},
+		".git/config":                      {Data: []byte("[core]")},
+		"app/.env":                         {Data: []byte("SECRET=2")},
+		"a/b/.htpasswd":                    {Data: []byte("admin:x")},
+		"assets/.cache/app.js":             {Data: []byte("cached")},
+		".well-known/security.txt":         {Data: []byte("Contact: x")},
+		".well-known/acme-challenge/token": {Data: []byte("token")},
+	}
+	denied := []string{"/.env", "/.git/config", "/app/.env", "/a/b/.htpasswd", "/assets/.cache/app.js"}
+	allowed := []string{"/.well-known/security.txt", "/.well-known/acme-challenge/token"}
+
+	h := testHandler(t, fsys, func(v *settings.Settings) {
+		v.DenyDotfiles = true
+	})
+	for _, target := range denied {
+		if w := do(h, http.MethodGet, target, nil); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", target, w.Code)
+		}
+	}
+	for _, target := range allowed {
+		if w := do(h, http.MethodGet, target, nil); w.Code != http.StatusOK {
+			t.Errorf("GET %s = %d, want 200", target, w.Code)
+		}
+	}
+
+	h = testHandler(t, fsys, func(v *settings.Settings) {
+		v.DenyDotfiles = false
+	})
+	for _, target := range denied {
+		if w := do(h, http.MethodGet, target, nil); w.Code != http.StatusOK {
+			t.Errorf("deny_dotfiles off: GET %s = %d, want 200", target, w.Code)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// headerWriter calls before right before the header is sent, when the
+// handler has set its headers.
+type headerWriter struct {
+	gin.ResponseWriter
+	before func(http.Header)
+	done   bool
+}
+
+func (w *headerWriter) sendHeader() {
+	if w.done {
+		return
+	}
+	w.done = true
+	w.before(w.Header())
+}
+
+// beforeHeader runs the handlers with before called right before the header
+// is sent. gin sends the header of responses without a body itself, after
+// the handlers, which is why before is also called then.
+func beforeHeader(c *gin.Context, before func(http.Header)) {
+	w := &headerWriter{ResponseWriter: c.Writer, before: before}
+	c.Writer = w
+	c.Next()
+	if !w.Written() {
+		w.sendHeader()
+	}
+}
+
+func (w *headerWriter) WriteHeaderNow() {
+	w.sendHeader()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *headerWriter) Write(data []byte) (int, error) {
+	w.sendHeader()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *headerWriter) WriteString(s string) (int, error) {
+	w.sendHeader()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *headerWriter) Flush() {
+	w.sendHeader()
+	w.ResponseWriter.Flush()
+}
+
+// headers sets the configured headers before the handler runs, which may
+// still replace them, e.g. Cache-Control of static files. Headers with an
+// empty value are removed from every response instead.
+func headers(h map[string]string) gin.HandlerFunc {
+	set := http.Header{}
+	var remove []string
+	for k, v := range h {
+		if v == "" {
+			remove = append(remove, k)
+			continue
+		}
+		set.Set(k, v)
+	}
+
+	return func(c *gin.Context) {
+		for k, v := range set {
+			c.Writer.Header()[k] = v
+		}
+		if len(remove) == 0 {
+			c.Next()
+			return
+		}
+		beforeHeader(c, func(h http.Header) {
+			for _, k := range remove {
+				h.Del(k)
+			}
+		})
+	}
+}
+
+// hsts sets Strict-Transport-Security on responses to requests made over
+// TLS; browsers ignore it on plain HTTP, where it is not sent.
+func hsts(maxAge int, includeSubDomains, preload bool) gin.HandlerFunc {
+	v := "max-age=" + strconv.Itoa(maxAge)
+	if includeSubDomains {
+		v += "; includeSubDomains"
+	}
+	if preload {
+		v += "; preload"
+	}
+	return func(c *gin.Context) {
+		if c.Request.TLS != nil {
+			c.Header("Strict-Transport-Security", v)
+		}
+		c.Next()
+	}
+}
+
+// csp sets the Content-Security-Policy headers of HTML responses.
+func csp(policy, reportOnly string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		beforeHeader(c, func(h http.Header) {
+			if !strings.HasPrefix(h.Get("Content-Type"), "text/html") {
+				return
+			}
+			if policy != "" {
+				h.Set("Content-Security-Policy", policy)
+			}
+			if reportOnly != "" {
+				h.Set("Content-Security-Policy-Report-Only", reportOnly)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type healthCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Err     string `json:"error,omitempty"`
+}
+
+// ErrSkipped is returned by a check which does not apply, such as that of
+// the log file while logging to stdout.
+var ErrSkipped = errors.New("skipped")
+
+// webRootCheckInterval is how long readiness trusts the last checkWebRoot,
+// which walks the web root and should not run on every probe.
+const webRootCheckInterval = 5 * time.Second
+
+type webRootCheck struct {
+	mu  sync.Mutex
+	at  time.Time
+	err error
+}
+
+// check returns the result of checkWebRoot within webRootCheckInterval of
+// the last walk. Concurrent probes wait for the same walk.
+func (w *webRootCheck) check(fsys fs.FS) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.at.IsZero() && time.Since(w.at) < webRootCheckInterval {
+		return w.err
+	}
+	w.err = checkWebRoot(fsys)
+	w.at = time.Now()
+	return w.err
+}
+
+type health struct {
+	Status string        `json:"status"`
+	Checks []healthCheck `json:"checks"`
+}
+
+// checkLogWritable writes and removes a probe file next to the log file,
+// which fails once the disk is full or the directory turned read-only.
+// GetLogs reads the same file.
+func (s *Server) checkLogWritable() error {
+	name := s.logger.Filename()
+	if name == "" {
+		return fmt.Errorf("%w: the log goes to stdout", ErrSkipped)
+	}
+	// the log file creates its directory as well
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".probe-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write([]byte{0}); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *Server) health() health {
+	checks := []struct {
+		name  string
+		check func() error
+	}{
+		{"log", s.checkLogWritable},
+	}
+
+	h := health{Status: "ok"}
+	for _, c := range checks {
+		v := healthCheck{Name: c.name, OK: true}
+		switch err := c.check(); {
+		case errors.Is(err, ErrSkipped):
+			v.OK, v.Skipped, v.Err = false, true, err.Error()
+		case err != nil:
+			v.OK, v.Err = false, err.Error()
+			h.Status = "degraded"
+		}
+		h.Checks = append(h.Checks, v)
+	}
+	return h
+}
+
+func (s *Server) Health(c *gin.Context) {
+	h := s.health()
+	code := http.StatusOK
+	if h.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(code, h)
+}
+
+// probePath reports whether p is a probe of orchestrators and load
+// balancers, which is answered without auth or redirect.
+func probePath(p string) bool {
+	return p == "/healthz" || p == "/readyz"
+}
+
+// Healthz tells that the server is alive; unlike Health it checks nothing.
+func (s *Server) Healthz(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, struct {
+		Status string `json:"status"`
+	}{"ok"})
+}
+
+var (
+	ErrStopping     = errors.New("server is stopping")
+	ErrNotListening = errors.New("server is not listening yet")
+)
+
+// readiness is nil once the settings validated and the http and, with TLS,
+// the https listener serve, until the server stops for a restart. The web
+// root and the log directory are checked on every call, so fixing them
+// later makes the server ready.
+func (s *Server) readiness() error {
+	want := int32(1)
+	if s.tlsEnabled() {
+		want++
+	}
+	switch {
+	case s.stopping.Load():
+		return ErrStopping
+	case s.configErr != nil:
+		return s.configErr
+	case s.listening.Load() < want:
+		return ErrNotListening
+	}
+	if err := s.webRoot.check(s.fs); err != nil {
+		return fmt.Errorf("web root: %w", err)
+	}
+	if err := s.checkLogWritable(); err != nil && !errors.Is(err, ErrSkipped) {
+		return fmt.Errorf("log: %w", err)
+	}
+	return nil
+}
+
+// Readyz tells whether requests should be sent to the server.
+func (s *Server) Readyz(c *gin.Context) {
+	res := struct {
+		Status string `json:"status"`
+		Reason string `json:"reason,omitempty"`
+	}{Status: "ready"}
+	code := http.StatusOK
+	if err := s.readiness(); err != nil {
+		res.Status, res.Reason = "unready", err.Error()
+		code = http.StatusServiceUnavailable
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(code, res)
+}
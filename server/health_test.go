@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"serv/settings"
+	"serv/zok/log"
+)
+
+func TestHealthLog(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	log.Open(log.Options{Mode: log.File, Filename: filepath.Join(dir, "app.log")})
+	t.Cleanup(func() {
+		log.Close()
+		log.Open(log.Options{})
+	})
+	s := New(ServerConfig{Settings: *settings.Value(), Logger: log.Default()})
+
+	if h := s.health(); h.Status != "ok" || !h.Checks[0].OK {
+		t.Fatalf("writable log directory: %+v", h)
+	}
+
+	// a file in place of the directory fails like a full disk
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if h := s.health(); h.Status != "degraded" || h.Checks[0].OK || h.Checks[0].Err == "" {
+		t.Fatalf("unusable log directory: %+v", h)
+	}
+
+	s = New(ServerConfig{Settings: *settings.Value()})
+	if err := s.checkLogWritable(); !errors.Is(err, ErrSkipped) {
+		t.Fatalf("logging to stdout: %v, want ErrSkipped", err)
+	}
+	if h := s.health(); h.Status != "ok" || !h.Checks[0].Skipped || h.Checks[0].OK {
+		t.Fatalf("logging to stdout: %+v", h)
+	}
+}
+
+// countFS counts the reads of the root directory, one per walk.
+type countFS struct {
+	fstest.MapFS
+	walks int
+}
+
+func (f *countFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name == "." {
+		f.walks++
+	}
+	return f.MapFS.ReadDir(name)
+}
+
+func TestWebRootCheckCached(t *testing.T) {
+	fsys := &countFS{MapFS: fstest.MapFS{"empty/.keep": {Mode: fs.ModeDir}}}
+	var w webRootCheck
+	for range 3 {
+		if err := w.check(fsys); err == nil {
+			t.Fatal("empty web root passed")
+		}
+	}
+	if fsys.walks != 1 {
+		t.Fatalf("walked %d times within the interval, want 1", fsys.walks)
+	}
+
+	// once the interval is over the web root is walked again
+	fsys.MapFS["index.html"] = &fstest.MapFile{Data: []byte("hi")}
+	w.at = w.at.Add(-webRootCheckInterval)
+	if err := w.check(fsys); err != nil {
+		t.Fatal(err)
+	}
+	if fsys.walks != 2 {
+		t.Fatalf("walked %d times, want 2", fsys.walks)
+	}
+}
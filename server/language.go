@@ -0,0 +1,49 @@
+package server
+
+import (
+	"cmp"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"serv/zok/header"
+)
+
+// languages returns the language tags of an Accept-Language header by
+// preference, each followed by its primary subtag: "zh-TW" also tries "zh".
+func languages(accept string) []string {
+	a := slices.Clone(header.ParseAccept(accept))
+	slices.SortStableFunc(a, func(x, y header.AcceptSpec) int {
+		return cmp.Compare(y.Q, x.Q)
+	})
+	var langs []string
+	for _, spec := range a {
+		if spec.Q < 0.001 || spec.Value == "*" {
+			continue
+		}
+		tag := strings.ToLower(spec.Value)
+		langs = append(langs, tag)
+		if primary, _, ok := strings.Cut(tag, "-"); ok {
+			langs = append(langs, primary)
+		}
+	}
+	return slices.Compact(langs)
+}
+
+// languageVariant finds the variant name.<lang>.ext of the file name for
+// the preferred language.
+func languageVariant(fsys fs.FS, name, accept string) (string, fs.FileInfo, string) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for _, lang := range languages(accept) {
+		if strings.ContainsAny(lang, "/.") {
+			continue
+		}
+		variant := base + "." + lang + ext
+		if fi, err := fs.Stat(fsys, variant); err == nil && fi.Mode().IsRegular() {
+			return variant, fi, lang
+		}
+	}
+	return "", nil, ""
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"net/http"
 	"os"
-	"path/filepath"
 
 	"github.com/gin-gonic/gin"
 
@@ -13,9 +12,19 @@ import (
 	"serv/zok/log"
 )
 
+// LogRotation is the rotation of the log files configured by v.
+func LogRotation(v *settings.Settings) log.Rotation {
+	return log.Rotation{
+		MaxSize:    v.LogMaxSize,
+		MaxBackups: v.LogMaxBackups,
+		MaxAge:     v.LogMaxAge.Value(),
+		Compress:   v.LogCompress,
+	}
+}
+
 func (s *Server) GetLogs(c *gin.Context) {
-	filename := filepath.Join(settings.Value().DataDirectory, log.Filename())
-	f, err := os.Open(filename)
+	// the file the logger writes, see checkLogWritable
+	f, err := os.Open(s.logger.Filename())
 	if err != nil {
 		return
 	}
@@ -36,7 +45,7 @@ func (s *Server) GetLogs(c *gin.Context) {
 }
 
 func (s *Server) DeleteLogs(c *gin.Context) {
-	if err := log.Rotate(); err != nil {
+	if err := s.logger.Rotate(); err != nil {
 		Abort500(c, err)
 		return
 	}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"os"
+	"strings"
+)
+
+// parseMimeTypes reads an nginx or Apache style mime.types file, one type
+// per line followed by its extensions. Lines without a media type are
+// skipped and counted.
+func parseMimeTypes(r io.Reader) (types map[string]string, skipped int, err error) {
+	types = map[string]string{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		line = strings.NewReplacer("{", " ", "}", " ", ";", " ").Replace(line)
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "types" {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || !strings.Contains(fields[0], "/") {
+			skipped++
+			continue
+		}
+		for _, ext := range fields[1:] {
+			types["."+strings.TrimPrefix(ext, ".")] = fields[0]
+		}
+	}
+	return types, skipped, sc.Err()
+}
+
+// loadMimeTypes registers the mappings of the mime.types file name.
+func (s *Server) loadMimeTypes(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	types, skipped, err := parseMimeTypes(f)
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		s.logger.Warnf("mime types: %s: skipped %d malformed lines", name, skipped)
+	}
+	for ext, typ := range types {
+		if err := mime.AddExtensionType(ext, typ); err != nil {
+			s.logger.Warnf("mime types: %s: %s %s: %v", name, ext, typ, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+
+	"serv/zok/header"
+)
+
+// sidecars are the precompressed variants looked for next to a file, in
+// order of preference.
+var sidecars = []struct{ encoding, ext string }{
+	{"zstd", ".zst"},
+	{"gzip", ".gz"},
+}
+
+// precompressed finds a variant of the file name, such as app.js.gz, in an
+// encoding the client accepts.
+func precompressed(fsys fs.FS, name, acceptEncoding string) (string, fs.FileInfo, string) {
+	if acceptEncoding == "" {
+		return "", nil, ""
+	}
+	a := header.ParseAcceptEncoding(acceptEncoding)
+	offers := make([]string, len(sidecars))
+	for i, v := range sidecars {
+		offers[i] = v.encoding
+	}
+	for _, enc := range a.Preferred(offers...) {
+		for _, v := range sidecars {
+			if v.encoding != enc {
+				continue
+			}
+			if fi, err := fs.Stat(fsys, name+v.ext); err == nil && fi.Mode().IsRegular() {
+				return name + v.ext, fi, v.encoding
+			}
+		}
+	}
+	return "", nil, ""
+}
+
+// contentType is the media type of the file name, which a precompressed
+// variant is served as.
+func contentType(fsys fs.FS, name string) string {
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		return ctype
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var buf [512]byte
+	n, _ := io.ReadFull(f, buf[:])
+	return http.DetectContentType(buf[:n])
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+const rateShards = 16
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateShard struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// rateLimiter is a token bucket per client, refilling rate tokens a second
+// up to burst. The clients are spread over shards, each with its own lock.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	seed   maphash.Seed
+	shards [rateShards]rateShard
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = max(1, int(math.Ceil(rate)))
+	}
+	l := &rateLimiter{rate: rate, burst: float64(burst), seed: maphash.MakeSeed()}
+	for i := range l.shards {
+		l.shards[i].buckets = map[string]*bucket{}
+	}
+	return l
+}
+
+// allow takes a token of the client, or reports how long until one is
+// available.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	sh := &l.shards[maphash.String(l.seed, key)%rateShards]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	b, ok := sh.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		sh.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// cleanup drops the buckets that have refilled, which are the same as new
+// ones, every interval until ctx is done.
+func (l *rateLimiter) cleanup(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			full := time.Duration(l.burst / l.rate * float64(time.Second))
+			for i := range l.shards {
+				sh := &l.shards[i]
+				sh.mu.Lock()
+				for k, b := range sh.buckets {
+					if now.Sub(b.last) >= full {
+						delete(sh.buckets, k)
+					}
+				}
+				sh.mu.Unlock()
+			}
+		}
+	}
+}
+
+// rateLimit answers 429 to clients sending more than RateLimit requests a
+// second, beyond bursts of RateBurst. Probes are not limited, kubelets and
+// load balancers send many from one address.
+func (s *Server) rateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if probePath(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		if ok, wait := s.limiter.allow(c.ClientIP(), time.Now()); !ok {
+			Abort429(c, ErrRateLimited, wait)
+			return
+		}
+		c.Next()
+	}
+}
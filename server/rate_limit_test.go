@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"serv/settings"
+)
+
+func TestRateLimitProbes(t *testing.T) {
+	h := testHandler(t, testFiles(), func(v *settings.Settings) {
+		v.RateLimit, v.RateBurst = 0.001, 1
+	})
+	if w := do(h, http.MethodGet, "/app.js", nil); w.Code != http.StatusOK {
+		t.Fatalf("first request = %d, want 200", w.Code)
+	}
+	if w := do(h, http.MethodGet, "/app.js", nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request = %d, want 429", w.Code)
+	}
+	for range 10 {
+		for _, target := range []string{"/healthz", "/readyz"} {
+			if w := do(h, http.MethodGet, target, nil); w.Code == http.StatusTooManyRequests {
+				t.Fatalf("GET %s = 429", target)
+			}
+		}
+	}
+}
@@ -2,6 +2,9 @@ package server
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -16,13 +19,35 @@ type ErrorResponse struct {
 }
 
 var (
-	AuthenticationError = Error{StatusCode: http.StatusUnauthorized, Message: "AuthenticationError Error"}
-	AuthorizationError  = Error{StatusCode: http.StatusForbidden, Message: "Authorization Error"}
-	NotFoundError       = Error{StatusCode: http.StatusNotFound, Message: "Not Found Error"}
-	BadRequestError     = Error{StatusCode: http.StatusBadRequest, Message: "Bad request"}
-	ServerError         = Error{StatusCode: http.StatusInternalServerError, Message: "Internal Server Error"}
+	AuthenticationError     = Error{StatusCode: http.StatusUnauthorized, Message: "AuthenticationError Error"}
+	AuthorizationError      = Error{StatusCode: http.StatusForbidden, Message: "Authorization Error"}
+	NotFoundError           = Error{StatusCode: http.StatusNotFound, Message: "Not Found Error"}
+	MethodNotAllowedError   = Error{StatusCode: http.StatusMethodNotAllowed, Message: "Method Not Allowed"}
+	BadRequestError         = Error{StatusCode: http.StatusBadRequest, Message: "Bad request"}
+	ServerError             = Error{StatusCode: http.StatusInternalServerError, Message: "Internal Server Error"}
+	TooManyRequestsError    = Error{StatusCode: http.StatusTooManyRequests, Message: "Too Many Requests"}
+	ServiceUnavailableError = Error{StatusCode: http.StatusServiceUnavailable, Message: "Service Unavailable"}
+	NotImplementedError     = Error{StatusCode: http.StatusNotImplemented, Message: "Not Implemented"}
 )
 
+// SetRetryAfter sets Retry-After in delay-seconds, rounded up. Non-positive
+// durations leave the header unset.
+func SetRetryAfter(c *gin.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	sec := int64((d + time.Second - 1) / time.Second)
+	c.Header("Retry-After", strconv.FormatInt(sec, 10))
+}
+
+// SetRetryAfterTime sets Retry-After as an HTTP date.
+func SetRetryAfterTime(c *gin.Context, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	c.Header("Retry-After", t.UTC().Format(http.TimeFormat))
+}
+
 func Abort500(c *gin.Context, err error) {
 	res := &ErrorResponse{Error: ServerError}
 	if err != nil {
@@ -67,3 +92,43 @@ func Abort404(c *gin.Context, err error) {
 	c.JSON(res.Error.StatusCode, res)
 	c.Abort()
 }
+
+// Abort405 responds 405 and lists the allowed methods in the Allow header.
+func Abort405(c *gin.Context, err error, allow ...string) {
+	res := &ErrorResponse{Error: MethodNotAllowedError}
+	if err != nil {
+		res.Error.Message = err.Error()
+	}
+	c.Header("Allow", strings.Join(allow, ", "))
+	c.JSON(res.Error.StatusCode, res)
+	c.Abort()
+}
+
+func Abort429(c *gin.Context, err error, retryAfter time.Duration) {
+	res := &ErrorResponse{Error: TooManyRequestsError}
+	if err != nil {
+		res.Error.Message = err.Error()
+	}
+	SetRetryAfter(c, retryAfter)
+	c.JSON(res.Error.StatusCode, res)
+	c.Abort()
+}
+
+func Abort501(c *gin.Context, err error) {
+	res := &ErrorResponse{Error: NotImplementedError}
+	if err != nil {
+		res.Error.Message = err.Error()
+	}
+	c.JSON(res.Error.StatusCode, res)
+	c.Abort()
+}
+
+func Abort503(c *gin.Context, err error, retryAfter time.Duration) {
+	res := &ErrorResponse{Error: ServiceUnavailableError}
+	if err != nil {
+		res.Error.Message = err.Error()
+	}
+	SetRetryAfter(c, retryAfter)
+	c.JSON(res.Error.StatusCode, res)
+	c.Abort()
+}
@@ -5,12 +5,10 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-
-	"serv/settings"
-	"serv/zok/log"
+	"serv/zok/compress"
 )
 
-func recovery() gin.HandlerFunc {
+func (s *Server) recovery() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		defer func() {
 			e := recover()
@@ -23,37 +21,108 @@ func recovery() gin.HandlerFunc {
 					panic(e)
 				}
 
-				log.Error(err)
+				s.logError(c, err)
 				return
 			}
 
-			log.Error(InternalServerError(e))
+			s.logError(c, InternalServerError(e))
 		}()
 
 		c.Next()
 	}
 }
 
-func (s *Server) buildRouter() http.Handler {
+func (s *Server) newEngine() *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	e := gin.New()
-	e.Use(recovery())
+	// X-Forwarded-For is only believed from these, for ClientIP
+	if err := e.SetTrustedProxies(s.settings.TrustedProxies); err != nil {
+		s.logger.Warn("trusted proxies:", err)
+	}
+	e.Use(requestID(), s.recovery())
+	if s.settings.AccessLog {
+		e.Use(s.accessLog())
+	}
+	if d := s.settings.SlowRequest.Value(); d > 0 {
+		e.Use(s.slowRequest(d))
+	}
+	if s.limiter != nil {
+		e.Use(s.rateLimit())
+	}
+	if s.settings.HSTSMaxAge > 0 {
+		e.Use(hsts(s.settings.HSTSMaxAge, s.settings.HSTSIncludeSubDomains, s.settings.HSTSPreload))
+	}
+	if v, r := s.settings.ContentSecurityPolicy, s.settings.ContentSecurityPolicyReportOnly; v != "" || r != "" {
+		e.Use(csp(v, r))
+	}
+	if len(s.settings.Headers) > 0 {
+		e.Use(headers(s.settings.Headers))
+	}
+	// before the auth, which does not apply to routes added earlier
+	e.GET("/healthz", s.Healthz)
+	e.GET("/readyz", s.Readyz)
+	if s.auth != nil && s.settings.BasicAuthScope != "api" {
+		e.Use(s.auth)
+	}
+	return e
+}
+
+// buildRouter returns the public handler and, when AdminPort is set, the
+// admin handler which then is the only one serving /vapi.
+func (s *Server) buildRouter() (handler, admin http.Handler) {
+	e := s.newEngine()
 	e.NoRoute(s.fileServe())
 
+	if s.settings.AdminPort == 0 {
+		s.routeAPI(e)
+		return e, nil
+	}
+
+	a := s.newEngine()
+	s.routeAPI(a)
+	return e, a
+}
+
+func (s *Server) routeAPI(e *gin.Engine) {
 	api := e.Group("/vapi")
+	if v := s.settings.CORSAllowedOrigins; len(v) > 0 {
+		api.Use(cors(v, s.settings.CORSAllowedMethods, s.settings.CORSAllowedHeaders, s.settings.CORSAllowCredentials))
+		// preflight requests match no other route
+		api.OPTIONS("/*path", func(c *gin.Context) {
+			c.Status(http.StatusNoContent)
+		})
+	}
+	if s.auth != nil && s.settings.BasicAuthScope == "api" {
+		api.Use(s.auth)
+	}
+	api.Use(compress.Middleware(s.compression))
+	if s.settings.Debug && s.settings.BodyLog > 0 {
+		api.Use(s.bodyLog(s.settings.BodyLog, s.settings.BodyLogRedact, s.settings.BodyLogRedactHeaders))
+	}
 	{
 		api.GET("/version", func(c *gin.Context) {
-			c.String(http.StatusOK, settings.Version)
+			c.String(http.StatusOK, s.version)
 		})
 
+		api.GET("/status", s.Status)
+		api.GET("/health", s.Health)
+		api.GET("/system", s.System)
+		api.GET("/stats", s.Stats)
+
 		api.GET("/logs", s.GetLogs)
 		api.DELETE("/logs", s.DeleteLogs)
 
+		if s.settings.Debug {
+			api.POST("/gc", s.GC)
+		}
+
+		if s.reload != nil {
+			api.POST("/reload", s.Reload)
+		}
+
 		api.POST("/records/apply", func(c *gin.Context) {
 			s.apply <- struct{}{}
 			c.JSON(200, struct{}{})
 		})
 	}
-
-	return e
 }
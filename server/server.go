@@ -6,31 +6,153 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"math/rand/v2"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme/autocert"
 
 	"serv/settings"
+	"serv/zok/compress"
 	"serv/zok/log"
 )
 
+// ServerConfig holds everything a Server depends on, so that it can run
+// without the package level settings and log state.
+type ServerConfig struct {
+	Settings settings.Settings
+	// Version is reported by /vapi/version and /vapi/status.
+	Version string
+	Logger  *log.Logger
+	// FS serves the static files. The web root on disk is used when nil.
+	FS fs.FS
+	// Started is when the process started; Restart tells how often and why
+	// the server has been restarted since.
+	Started time.Time
+	Restart Restart
+	// Events receives the lifecycle events, if not nil. Events are dropped
+	// while the channel is full.
+	Events chan<- Event
+	// Reload reads the settings again, restarts the server if they changed
+	// and returns the settings in effect. POST /vapi/reload is not routed
+	// when nil.
+	Reload func(ctx context.Context) (changed bool, current settings.Settings, err error)
+	// Certificate, if not nil, is served instead of loading the certificate
+	// from the settings, so that it can be reloaded without a restart.
+	Certificate *Certificate
+}
+
 type Server struct {
-	handler http.Handler
-	apply   chan struct{}
+	settings *settings.Settings
+	logger   *log.Logger
+	fs       fs.FS
+	handler  http.Handler
+	// adminHandler serves /vapi on its own listener when AdminPort is set.
+	adminHandler http.Handler
+	apply        chan struct{}
+	compression  compress.Options
+	policy       *compressPolicy
+	etags        *etags
+	// auth requires Basic authentication, if not nil.
+	auth    gin.HandlerFunc
+	limiter *rateLimiter
+	// accessFile is the access log, if not written to the log
+	accessFile *log.LogrotateWriter
+	rand       func() float64
+	// webRootOK is false when the startup check found no files to serve.
+	webRootOK bool
+	// webRoot caches checkWebRoot for readiness
+	webRoot webRootCheck
+	// configErr is why the settings did not validate, see Readyz
+	configErr error
+	// listening counts the http and https listeners serving
+	listening atomic.Int32
+	stopping  atomic.Bool
+	started   time.Time
+	restart   Restart
+	events    chan<- Event
+	reload    func(ctx context.Context) (bool, settings.Settings, error)
+	version   string
+	cert      *Certificate
+	acme      *autocert.Manager
 }
 
-func New() *Server {
-	return &Server{
-		apply: make(chan struct{}, 1),
+func New(cfg ServerConfig) *Server {
+	s := &Server{
+		settings: &cfg.Settings,
+		logger:   cfg.Logger,
+		fs:       cfg.FS,
+		apply:    make(chan struct{}, 1),
+		rand:     rand.Float64,
+		started:  cfg.Started,
+		restart:  cfg.Restart,
+		events:   cfg.Events,
+		reload:   cfg.Reload,
+		version:  cfg.Version,
+		cert:     cfg.Certificate,
+	}
+	if s.started.IsZero() {
+		s.started = time.Now()
+	}
+	if s.logger == nil {
+		s.logger = log.New(zap.NewNop())
 	}
+	return s
 }
 
 func (s *Server) init(ctx context.Context) (err error) {
-	s.handler = s.buildRouter()
+	if s.fs == nil {
+		s.fs = os.DirFS(filepath.Join(s.settings.DataDirectory, s.settings.WebRoot))
+	}
+	if err := s.webRoot.check(s.fs); err != nil {
+		s.logger.Warn("web root:", err, "(every static request will 404 until it is populated)")
+	} else {
+		s.webRootOK = true
+	}
+	if name := s.settings.MimeTypes; name != "" {
+		if err := s.loadMimeTypes(name); err != nil {
+			s.logger.Warn("mime types:", err)
+		}
+	}
+	level, _ := compress.ParseLevel(s.settings.CompressLevel)
+	s.compression = compress.Options{
+		Disable: !s.settings.Compress,
+		Digest:  s.settings.CompressDigest,
+		Debug:   s.settings.CompressDebug,
+		MinSize: s.settings.CompressMinSize,
+		// validated with the settings
+		Level: level,
+		OnError: func(err error) {
+			s.logger.Warn("compress:", err)
+		},
+		OnCloseError: func(err error) {
+			if !clientGone(nil, err) {
+				s.logger.Debug("compress close:", err)
+			}
+		},
+	}
+	s.policy = newCompressPolicy(s.settings.CompressPolicy, s.settings.CompressAutoRatio)
+	s.etags = &etags{weak: s.settings.WeakETag}
+	s.configErr = s.settings.Validate()
+	s.auth = s.basicAuth()
+	if s.settings.AccessLog && s.settings.AccessLogFile != "" {
+		s.accessFile = log.NewFile(s.settings.AccessLogFile, LogRotation(s.settings))
+	}
+	if s.settings.RateLimit > 0 {
+		s.limiter = newRateLimiter(s.settings.RateLimit, s.settings.RateBurst)
+		go s.limiter.cleanup(ctx, time.Minute)
+	}
+	s.acme = s.acmeManager()
+	s.handler, s.adminHandler = s.buildRouter()
 	return nil
 }
 
@@ -40,41 +162,75 @@ func (s *Server) Run(ctx context.Context) {
 	}
 
 	wg := &sync.WaitGroup{}
-	wg.Add(2)
+	wg.Add(3)
 	go func() {
 		defer wg.Done()
 		_ = s.serveHTTP(ctx)
 	}()
 	go func() {
 		defer wg.Done()
-		if settings.Value().TLSCertificate == "" && settings.Value().TLSKey == "" {
+		if s.adminHandler == nil {
+			return
+		}
+		_ = s.serveAdmin(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		if !s.tlsEnabled() {
 			return
 		}
 		err := s.serveHTTPS(ctx)
 		if errors.Is(err, fs.ErrNotExist) {
-			log.Info("TLS certificate is not found.")
+			s.logger.Info("TLS certificate is not found.")
 			return
 		}
 		if !errors.Is(err, http.ErrServerClosed) {
-			log.Error(err)
+			s.logger.Error(err)
 		}
 	}()
 	wg.Wait()
+
+	if s.accessFile != nil {
+		if err := s.accessFile.Close(); err != nil {
+			s.logger.Error(err)
+		}
+	}
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+func isAPIPath(p string) bool {
+	return p == "/vapi" || strings.HasPrefix(p, "/vapi/")
 }
 
 func (s *Server) redirect(handler http.Handler) http.Handler {
-	const redirect = false
+	redirect := s.settings.RedirectToHTTPS && s.tlsEnabled()
+	apiTLSOnly := s.settings.APITLSOnly
+	port := ""
+	if s.settings.ServeTLSPort != 443 {
+		port = strconv.Itoa(s.settings.ServeTLSPort)
+	}
 
 	h := gin.New()
 	h.Any("/*any", func(c *gin.Context) {
-		if redirect {
+		if apiTLSOnly && isAPIPath(c.Request.URL.Path) {
+			Abort404(c, nil)
+			return
+		}
+		// only safe methods are redirected, a POST body would be lost
+		if redirect && safeMethod(c.Request.Method) && !probePath(c.Request.URL.Path) {
 			host, _, err := net.SplitHostPort(c.Request.Host)
 			if err != nil {
 				host = c.Request.Host
 			}
 			u := *c.Request.URL
 			u.Scheme = "https"
-			u.Host = net.JoinHostPort(host, strconv.Itoa(settings.Value().ServeTLSPort))
+			u.Host = host
+			if port != "" {
+				u.Host = net.JoinHostPort(host, port)
+			}
 			c.Header("Cache-Control", "no-store")
 			c.Redirect(http.StatusMovedPermanently, u.String())
 			return
@@ -85,92 +241,242 @@ func (s *Server) redirect(handler http.Handler) http.Handler {
 	return h
 }
 
-func serve(srv *http.Server, onListenSuccess func()) error {
-	ln, err := net.Listen("tcp", srv.Addr)
+var ErrNetwork = errors.New("unsupported listen network")
+
+// listen opens the listeners for network. "dual" binds tcp4 and tcp6
+// separately; Go sets IPV6_V6ONLY on the tcp6 socket, so this works even when
+// net.ipv6.bindv6only is 0. Plain "tcp" relies on the kernel's dual-stack
+// behavior and becomes IPv6-only when bindv6only is 1.
+func listen(network, addr string) ([]net.Listener, error) {
+	switch network {
+	case "", "tcp", "tcp4", "tcp6":
+		if network == "" {
+			network = "tcp"
+		}
+		ln, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{ln}, nil
+	case "unix":
+		// a socket left behind by a process that did not shut down cleanly
+		if fi, err := os.Lstat(addr); err == nil && fi.Mode()&fs.ModeSocket != 0 {
+			os.Remove(addr)
+		}
+		// closing the listener unlinks the socket
+		ln, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{ln}, nil
+	case "dual":
+		ln4, err := net.Listen("tcp4", addr)
+		if err != nil {
+			return nil, err
+		}
+		ln6, err := net.Listen("tcp6", addr)
+		if err != nil {
+			ln4.Close()
+			return nil, err
+		}
+		return []net.Listener{ln4, ln6}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrNetwork, network)
+}
+
+func serve(srv *http.Server, network string, onListenSuccess func()) error {
+	lns, err := listen(network, srv.Addr)
 	if err != nil {
 		return err
 	}
-	defer ln.Close()
+	defer func() {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	}()
 	if onListenSuccess != nil {
 		onListenSuccess()
 	}
-	return srv.Serve(ln)
+
+	// Serve may assign srv.TLSConfig while configuring HTTP/2, so decide up front.
+	useTLS := srv.TLSConfig != nil
+	errs := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func(ln net.Listener) {
+			if useTLS {
+				errs <- srv.ServeTLS(ln, "", "")
+				return
+			}
+			errs <- srv.Serve(ln)
+		}(ln)
+	}
+
+	err = <-errs
+	for _, ln := range lns {
+		ln.Close()
+	}
+	for range len(lns) - 1 {
+		<-errs
+	}
+	return err
 }
 
 func (s *Server) serveHTTP(ctx context.Context) error {
 	srv := &http.Server{
-		Addr:    net.JoinHostPort("", strconv.FormatInt(int64(settings.Value().ServePort), 10)),
+		Addr:    net.JoinHostPort(s.settings.BindAddress, strconv.FormatInt(int64(s.settings.ServePort), 10)),
 		Handler: s.redirect(s.handler),
 	}
+	network := s.settings.Network
+	if s.settings.UnixSocket != "" {
+		srv.Addr, network = s.settings.UnixSocket, "unix"
+	}
+	if s.acme != nil {
+		// answers the http-01 challenges, everything else goes on
+		srv.Handler = s.acme.HTTPHandler(srv.Handler)
+	}
+	return s.listenAndServe(ctx, srv, network, "http")
+}
+
+func (s *Server) serveAdmin(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:    net.JoinHostPort(s.settings.AdminBind, strconv.Itoa(s.settings.AdminPort)),
+		Handler: s.adminHandler,
+	}
+	// the bind address picks the address family
+	return s.listenAndServe(ctx, srv, "tcp", "admin")
+}
+
+// listenAndServe serves srv until ctx is done, retrying with backoff while
+// the address cannot be listened on.
+func (s *Server) listenAndServe(ctx context.Context, srv *http.Server, network, name string) error {
+	srv.SetKeepAlivesEnabled(s.settings.KeepAlive)
 
+	shutdown := make(chan struct{})
 	go func() {
+		defer close(shutdown)
 		<-ctx.Done()
-		_ = srv.Shutdown(ctx)
+		s.stopping.Store(true)
+		Emit(s.events, Event{Kind: EventShuttingDown, Listener: name, Addr: srv.Addr, Err: context.Cause(ctx)})
+		s.shutdown(srv, name)
 	}()
 
+	b := s.listenBackoff()
 	for {
 		select {
 		default:
 		case <-ctx.Done():
+			<-shutdown
 			return ctx.Err()
 		}
 
-		err := serve(srv, func() {
-			log.Info("http server listen:", srv.Addr)
+		listened := false
+		err := serve(srv, network, func() {
+			if name != "admin" {
+				s.listening.Add(1)
+				listened = true
+			}
+			if network == "unix" {
+				mode, _ := strconv.ParseUint(s.settings.UnixSocketMode, 8, 32)
+				if err := os.Chmod(srv.Addr, fs.FileMode(mode)); err != nil {
+					s.logger.Warn(name+" server socket:", err)
+				}
+			}
+			s.logger.Info(name+" server listen:", srv.Addr)
+			Emit(s.events, Event{Kind: EventListening, Listener: name, Addr: srv.Addr})
 		})
+		if listened {
+			s.listening.Add(-1)
+		}
 
 		if err == nil {
 			panic("unexpected behavior")
 		}
 
 		if errors.Is(err, http.ErrServerClosed) {
+			// Serve returns once shutdown begins, the requests are
+			// still running
+			<-shutdown
 			return err
 		}
 
-		log.Warn("http server listen:", err)
-		time.Sleep(time.Second)
+		Emit(s.events, Event{Kind: EventListenFailed, Listener: name, Addr: srv.Addr, Err: err})
+		d := b.next()
+		s.logger.Warn(name+" server listen:", err, "(retry in "+d.Round(time.Millisecond).String()+")")
+		sleep(ctx, d)
 	}
 }
 
-func (s *Server) serveHTTPS(ctx context.Context) error {
-	GetCertificate, err := X509KeyPair(settings.Value().TLSCertificate, settings.Value().TLSKey)
-	if err != nil {
-		return fmt.Errorf("serve TLS: %w", err)
+// shutdown lets the requests in flight run for ShutdownTimeout, then
+// closes their connections.
+func (s *Server) shutdown(srv *http.Server, name string) {
+	timeout := s.settings.ShutdownTimeout.Value()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); errors.Is(err, context.DeadlineExceeded) {
+		s.logger.Warn(name+" server shutdown: requests still running after", timeout.String(), "(closing their connections)")
+		_ = srv.Close()
 	}
+}
 
-	srv := &http.Server{
-		Addr:    net.JoinHostPort("", strconv.FormatInt(int64(settings.Value().ServeTLSPort), 10)),
-		Handler: s.handler,
-		TLSConfig: &tls.Config{
-			GetCertificate: GetCertificate,
-		},
-	}
+func (s *Server) listenBackoff() *backoff {
+	return &backoff{min: 500 * time.Millisecond, max: 30 * time.Second, rand: s.rand}
+}
 
-	go func() {
-		<-ctx.Done()
-		_ = srv.Shutdown(ctx)
-	}()
+func (s *Server) tlsEnabled() bool {
+	return len(s.settings.ACMEDomains) > 0 || len(s.settings.TLSHosts) > 0 ||
+		s.settings.TLSCertificate != "" || s.settings.TLSKey != "" || s.settings.TLSPfx != "" ||
+		s.settings.TLSCertificatePEM != "" || s.settings.TLSKeyPEM != ""
+}
 
-	for {
-		select {
-		default:
-		case <-ctx.Done():
-			return ctx.Err()
+func (s *Server) certificate() (func(*tls.ClientHelloInfo) (*tls.Certificate, error), error) {
+	files := s.settings.TLSCertificate != "" || s.settings.TLSKey != ""
+	pfx := s.settings.TLSPfx != ""
+	inline := s.settings.TLSCertificatePEM != "" || s.settings.TLSKeyPEM != ""
+	if files && pfx || files && inline || pfx && inline {
+		return nil, ErrTLSSource
+	}
+	if s.cert != nil {
+		return s.cert.GetCertificate, nil
+	}
+	if len(s.settings.TLSHosts) > 0 {
+		c, err := NewCertificate(CertificateLoader(s.settings))
+		if err != nil {
+			return nil, err
 		}
+		return c.GetCertificate, nil
+	}
+	if pfx {
+		return X509Pfx(s.settings.TLSPfx, s.settings.TLSPfxPassphrase)
+	}
+	if inline {
+		return X509KeyPairPEM([]byte(s.settings.TLSCertificatePEM), []byte(s.settings.TLSKeyPEM))
+	}
+	return X509KeyPair(s.settings.TLSCertificate, s.settings.TLSKey)
+}
 
-		err := serve(srv, func() {
-			log.Info("https server listen:", srv.Addr)
-		})
-
-		if err == nil {
-			panic("unexpected behavior")
+func (s *Server) serveHTTPS(ctx context.Context) error {
+	var config *tls.Config
+	if s.acme != nil {
+		config = s.acme.TLSConfig()
+	} else {
+		GetCertificate, err := s.certificate()
+		if err != nil {
+			return fmt.Errorf("serve TLS: %w", err)
 		}
-
-		if errors.Is(err, http.ErrServerClosed) {
-			return err
+		config = &tls.Config{
+			GetCertificate: GetCertificate,
 		}
+	}
+
+	if err := s.clientAuth(config); err != nil {
+		return fmt.Errorf("serve TLS: %w", err)
+	}
 
-		log.Warn("https server listen:", err)
-		time.Sleep(time.Second)
+	srv := &http.Server{
+		Addr:      net.JoinHostPort(s.settings.BindAddress, strconv.FormatInt(int64(s.settings.ServeTLSPort), 10)),
+		Handler:   s.handler,
+		TLSConfig: config,
 	}
+
+	return s.listenAndServe(ctx, srv, s.settings.Network, "https")
 }
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"serv/settings"
+)
+
+func TestRedirect(t *testing.T) {
+	served := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	newRedirect := func(redirect bool, tlsPort int, cert string) http.Handler {
+		v := *settings.Value()
+		v.RedirectToHTTPS = redirect
+		v.ServeTLSPort = tlsPort
+		v.TLSCertificatePEM = cert
+		return New(ServerConfig{Settings: v}).redirect(served)
+	}
+
+	tests := []struct {
+		name     string
+		h        http.Handler
+		method   string
+		target   string
+		want     int
+		location string
+	}{
+		{"on", newRedirect(true, 8443, "cert"), http.MethodGet, "http://example.com:8080/a/b?x=1&y=2", http.StatusMovedPermanently, "https://example.com:8443/a/b?x=1&y=2"},
+		{"on, HEAD", newRedirect(true, 8443, "cert"), http.MethodHead, "http://example.com/a", http.StatusMovedPermanently, "https://example.com:8443/a"},
+		{"on, port 443", newRedirect(true, 443, "cert"), http.MethodGet, "http://example.com:8080/", http.StatusMovedPermanently, "https://example.com/"},
+		{"on, POST", newRedirect(true, 8443, "cert"), http.MethodPost, "http://example.com/vapi/reload", http.StatusOK, ""},
+		{"on, probe", newRedirect(true, 8443, "cert"), http.MethodGet, "http://example.com/healthz", http.StatusOK, ""},
+		{"on, without TLS", newRedirect(true, 8443, ""), http.MethodGet, "http://example.com/a", http.StatusOK, ""},
+		{"off", newRedirect(false, 8443, "cert"), http.MethodGet, "http://example.com/a", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Restart describes why the server was restarted.
+type Restart struct {
+	Count  int       `json:"count"`
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+type status struct {
+	Version   string    `json:"version"`
+	Started   time.Time `json:"started"`
+	Uptime    string    `json:"uptime"`
+	Restarts  Restart   `json:"restarts"`
+	WebRootOK bool      `json:"web_root_ok"`
+}
+
+func (s *Server) Status(c *gin.Context) {
+	c.JSON(http.StatusOK, status{
+		Version:   s.version,
+		Started:   s.started,
+		Uptime:    time.Since(s.started).Round(time.Second).String(),
+		Restarts:  s.restart,
+		WebRootOK: s.webRootOK,
+	})
+}
+
+// Reload applies the config file like a change seen by the file watcher and
+// responds the settings now in effect.
+func (s *Server) Reload(c *gin.Context) {
+	changed, v, err := s.reload(c.Request.Context())
+	if err != nil {
+		AbortBadRequestError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"changed":  changed,
+		"settings": v.Redacted(),
+	})
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"serv/zok/proc"
+)
+
+type hostStats struct {
+	MemTotal     uint64  `json:"mem_total_kb,omitempty"`
+	MemAvailable uint64  `json:"mem_available_kb,omitempty"`
+	RSS          uint64  `json:"rss_kb,omitempty"`
+	VMSize       uint64  `json:"vm_size_kb,omitempty"`
+	Uptime       float64 `json:"uptime_seconds,omitempty"`
+}
+
+type goStats struct {
+	Version      string `json:"version"`
+	GOMAXPROCS   int    `json:"gomaxprocs"`
+	NumGoroutine int    `json:"num_goroutine"`
+	HeapAlloc    uint64 `json:"heap_alloc"`
+	HeapSys      uint64 `json:"heap_sys"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	Sys          uint64 `json:"sys"`
+	NumGC        uint32 `json:"num_gc"`
+	PauseTotalNs uint64 `json:"gc_pause_total_ns"`
+	LastPauseNs  uint64 `json:"gc_last_pause_ns"`
+}
+
+// memStatsEvery bounds how often ReadMemStats, which stops the world, runs
+// on behalf of the stats endpoints.
+const memStatsEvery = time.Second
+
+var memStatsCache struct {
+	sync.Mutex
+	at time.Time
+	m  runtime.MemStats
+}
+
+func cachedMemStats() runtime.MemStats {
+	memStatsCache.Lock()
+	defer memStatsCache.Unlock()
+	if time.Since(memStatsCache.at) >= memStatsEvery {
+		runtime.ReadMemStats(&memStatsCache.m)
+		memStatsCache.at = time.Now()
+	}
+	return memStatsCache.m
+}
+
+func readGoStats() goStats {
+	m := cachedMemStats()
+	return goStats{
+		Version:      runtime.Version(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		NumGoroutine: runtime.NumGoroutine(),
+		HeapAlloc:    m.HeapAlloc,
+		HeapSys:      m.HeapSys,
+		HeapObjects:  m.HeapObjects,
+		Sys:          m.Sys,
+		NumGC:        m.NumGC,
+		PauseTotalNs: m.PauseTotalNs,
+		LastPauseNs:  m.PauseNs[(m.NumGC+255)%256],
+	}
+}
+
+func readHostStats() hostStats {
+	var v hostStats
+	if m, err := proc.Memory(); err == nil {
+		v.MemTotal, v.MemAvailable = m.MemTotal, m.MemAvailable
+	}
+	if st, err := proc.SelfStatus(); err == nil {
+		v.RSS, v.VMSize = st.VMRss, st.VMSize
+	}
+	if u, err := proc.Uptime(); err == nil {
+		v.Uptime = u.Uptime
+	}
+	return v
+}
+
+// System reports the host statistics from /proc next to the Go runtime's
+// own view of the process.
+func (s *Server) System(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"host": readHostStats(),
+		"go":   readGoStats(),
+	})
+}
+
+var ErrNoProc = errors.New("/proc is not available")
+
+type procStats struct {
+	Memory  *proc.Memmoryinfo       `json:"memory"`
+	CPU     map[string]proc.CPUStat `json:"cpu"`
+	Uptime  *proc.UptimeData        `json:"uptime"`
+	LoadAvg *proc.LoadAvgData       `json:"loadavg"`
+	Process *proc.ProcStatus        `json:"process"`
+	// CPUPercent is the share of the machine's CPU time the process used
+	// over cpuSampleInterval.
+	CPUPercent float64   `json:"cpu_percent"`
+	Disk       diskStats `json:"disk"`
+}
+
+// cpuSampleInterval is how long Stats samples the CPU usage of the process.
+const cpuSampleInterval = 100 * time.Millisecond
+
+type diskStats struct {
+	Path  string `json:"path"`
+	Total uint64 `json:"total"`
+	Free  uint64 `json:"free"`
+	Used  uint64 `json:"used"`
+	Err   string `json:"error,omitempty"`
+}
+
+func abortProc(c *gin.Context, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		Abort501(c, ErrNoProc)
+		return
+	}
+	Abort500(c, err)
+}
+
+// Stats reports memory, CPU, uptime and load of the host and the status and
+// CPU usage of the process as read from /proc, which only Linux has, and the
+// disk usage of the data directory.
+func (s *Server) Stats(c *gin.Context) {
+	var v procStats
+	var err error
+	if v.Memory, err = proc.Memory(); err != nil {
+		abortProc(c, err)
+		return
+	}
+	if v.CPU, err = proc.Stat(); err != nil {
+		abortProc(c, err)
+		return
+	}
+	if v.Uptime, err = proc.Uptime(); err != nil {
+		abortProc(c, err)
+		return
+	}
+	if v.LoadAvg, err = proc.LoadAvg(); err != nil {
+		abortProc(c, err)
+		return
+	}
+	if v.Process, err = proc.SelfStatus(); err != nil {
+		abortProc(c, err)
+		return
+	}
+	if v.CPUPercent, err = proc.CPUPercent(os.Getpid(), cpuSampleInterval); err != nil {
+		abortProc(c, err)
+		return
+	}
+	v.Disk.Path = s.settings.DataDirectory
+	// the rest is still worth reporting without the data directory
+	if v.Disk.Total, v.Disk.Free, v.Disk.Used, err = proc.DiskUsage(v.Disk.Path); err != nil {
+		v.Disk.Err = err.Error()
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, v)
+}
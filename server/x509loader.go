@@ -2,12 +2,24 @@ package server
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"os"
+	"strings"
+	"sync/atomic"
 
 	"golang.org/x/crypto/pkcs12"
+
+	"serv/settings"
 )
 
-func X509Pfx(pfxFile string, passphrase string) (func(*tls.ClientHelloInfo) (*tls.Certificate, error), error) {
+var (
+	ErrTLSSource = errors.New("configure only one of TLS certificate files, a PFX file or PEM contents")
+	ErrClientCA  = errors.New("no certificates found in the client CA file")
+)
+
+func loadPfx(pfxFile string, passphrase string) (*tls.Certificate, error) {
 	data, err := os.ReadFile(pfxFile)
 	if err != nil {
 		return nil, err
@@ -21,9 +33,16 @@ func X509Pfx(pfxFile string, passphrase string) (func(*tls.ClientHelloInfo) (*tl
 
 	c.PrivateKey = key
 	c.Certificate = [][]byte{cert.Raw}
+	return &c, nil
+}
 
+func X509Pfx(pfxFile string, passphrase string) (func(*tls.ClientHelloInfo) (*tls.Certificate, error), error) {
+	c, err := loadPfx(pfxFile, passphrase)
+	if err != nil {
+		return nil, err
+	}
 	return func(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-		return &c, nil
+		return c, nil
 	}, nil
 }
 
@@ -36,3 +55,157 @@ func X509KeyPair(certFile string, keyFile string) (func(*tls.ClientHelloInfo) (*
 		return &c, nil
 	}, nil
 }
+
+func X509KeyPairPEM(certPEM []byte, keyPEM []byte) (func(*tls.ClientHelloInfo) (*tls.Certificate, error), error) {
+	c, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return func(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return &c, nil
+	}, nil
+}
+
+// Certificates are the certificates of the https listener, one for each
+// SNI host name and the default for other names.
+type Certificates struct {
+	Default *tls.Certificate
+	Hosts   map[string]*tls.Certificate
+}
+
+var ErrNoCertificate = errors.New("no certificate for the server name")
+
+// get returns the certificate of the server name, of a *.example.com
+// wildcard matching it, or the default.
+func (c *Certificates) get(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if v, ok := c.Hosts[name]; ok {
+		return v, nil
+	}
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		if v, ok := c.Hosts["*."+parent]; ok {
+			return v, nil
+		}
+	}
+	if c.Default == nil {
+		return nil, fmt.Errorf("%w %q", ErrNoCertificate, hello.ServerName)
+	}
+	return c.Default, nil
+}
+
+// CertificateLoader reads the certificates of v: the default from the PFX
+// file, the certificate and key files or the PEM contents, and those of
+// TLSHosts. It returns nil when v configures none of them.
+func CertificateLoader(v *settings.Settings) func() (*Certificates, error) {
+	if len(v.ACMEDomains) > 0 {
+		return nil
+	}
+	var load func() (*tls.Certificate, error)
+	switch {
+	case v.TLSPfx != "":
+		file, passphrase := v.TLSPfx, v.TLSPfxPassphrase
+		load = func() (*tls.Certificate, error) {
+			return loadPfx(file, passphrase)
+		}
+	case v.TLSCertificate != "" || v.TLSKey != "":
+		load = keyPair(v.TLSCertificate, v.TLSKey)
+	case v.TLSCertificatePEM != "" || v.TLSKeyPEM != "":
+		certPEM, keyPEM := []byte(v.TLSCertificatePEM), []byte(v.TLSKeyPEM)
+		load = func() (*tls.Certificate, error) {
+			c, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				return nil, err
+			}
+			return &c, nil
+		}
+	}
+	if load == nil && len(v.TLSHosts) == 0 {
+		return nil
+	}
+
+	hosts := make(map[string]func() (*tls.Certificate, error), len(v.TLSHosts))
+	for name, pair := range v.TLSHosts {
+		hosts[strings.ToLower(name)] = keyPair(pair.Cert, pair.Key)
+	}
+
+	return func() (*Certificates, error) {
+		c := &Certificates{Hosts: make(map[string]*tls.Certificate, len(hosts))}
+		if load != nil {
+			v, err := load()
+			if err != nil {
+				return nil, err
+			}
+			c.Default = v
+		}
+		for name, load := range hosts {
+			v, err := load()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			c.Hosts[name] = v
+		}
+		return c, nil
+	}
+}
+
+func keyPair(certFile, keyFile string) func() (*tls.Certificate, error) {
+	return func() (*tls.Certificate, error) {
+		c, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &c, nil
+	}
+}
+
+// Certificate holds the certificates served to TLS handshakes. Reload swaps
+// them in place, established connections keep the one they started with.
+type Certificate struct {
+	load func() (*Certificates, error)
+	cert atomic.Pointer[Certificates]
+}
+
+func NewCertificate(load func() (*Certificates, error)) (*Certificate, error) {
+	c := &Certificate{load: load}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads the certificates again; on error the current ones stay.
+func (c *Certificate) Reload() error {
+	v, err := c.load()
+	if err != nil {
+		return err
+	}
+	c.cert.Store(v)
+	return nil
+}
+
+func (c *Certificate) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load().get(hello)
+}
+
+// clientAuth has config ask for client certificates signed by TLSClientCA,
+// when set. Handshakes with a missing or invalid certificate fail, unless
+// the mode is verify, which lets clients without a certificate through.
+func (s *Server) clientAuth(config *tls.Config) error {
+	if s.settings.TLSClientCA == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.settings.TLSClientCA)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("%w: %s", ErrClientCA, s.settings.TLSClientCA)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	if s.settings.TLSClientAuth == "verify" {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return nil
+}
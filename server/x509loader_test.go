@@ -0,0 +1,145 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"serv/settings"
+)
+
+// writeCertificate writes a self-signed certificate for host and its key
+// to dir and returns the certificate.
+func writeCertificate(t *testing.T, dir, host string) (pair settings.TLSPair, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair = settings.TLSPair{Cert: filepath.Join(dir, host+".crt"), Key: filepath.Join(dir, host+".key")}
+	if err := os.WriteFile(pair.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pair.Key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return pair, cert
+}
+
+func TestCertificateSNI(t *testing.T) {
+	dir := t.TempDir()
+	a, certA := writeCertificate(t, dir, "a.example.com")
+	b, certB := writeCertificate(t, dir, "b.example.com")
+	def, certDefault := writeCertificate(t, dir, "default.example.com")
+
+	v := *settings.Value()
+	v.TLSCertificate, v.TLSKey = def.Cert, def.Key
+	v.TLSHosts = map[string]settings.TLSPair{
+		"a.example.com": a,
+		"B.Example.com": b,
+	}
+	c, err := NewCertificate(CertificateLoader(&v))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		serverName string
+		want       *x509.Certificate
+	}{
+		{"a.example.com", certA},
+		{"b.example.com", certB},
+		{"B.EXAMPLE.COM.", certB},
+		{"c.example.com", certDefault},
+		{"", certDefault},
+	}
+	for _, tt := range tests {
+		got, err := c.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+		if err != nil {
+			t.Fatalf("%q: %v", tt.serverName, err)
+		}
+		if !tt.want.Equal(leaf(t, got)) {
+			t.Errorf("%q: got the certificate of %s, want %s", tt.serverName, leaf(t, got).Subject.CommonName, tt.want.Subject.CommonName)
+		}
+	}
+
+	// a handshake verifies the certificate of each host name
+	for _, tt := range tests[:2] {
+		roots := x509.NewCertPool()
+		roots.AddCert(tt.want)
+		if err := handshake(c, &tls.Config{ServerName: tt.serverName, RootCAs: roots}); err != nil {
+			t.Errorf("handshake with %s: %v", tt.serverName, err)
+		}
+	}
+
+	// without a default, other names have no certificate
+	v.TLSCertificate, v.TLSKey = "", ""
+	c, err = NewCertificate(CertificateLoader(&v))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetCertificate(&tls.ClientHelloInfo{ServerName: "c.example.com"}); !errors.Is(err, ErrNoCertificate) {
+		t.Errorf("c.example.com without a default: %v, want ErrNoCertificate", err)
+	}
+}
+
+func leaf(t *testing.T, c *tls.Certificate) *x509.Certificate {
+	t.Helper()
+	if c.Leaf != nil {
+		return c.Leaf
+	}
+	v, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func handshake(c *Certificate, config *tls.Config) error {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- tls.Server(server, &tls.Config{GetCertificate: c.GetCertificate}).Handshake()
+	}()
+	err := tls.Client(client, config).Handshake()
+	if err != nil {
+		client.Close()
+	}
+	if err2 := <-errc; err == nil {
+		err = err2
+	}
+	return err
+}
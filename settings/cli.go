@@ -1,6 +1,7 @@
 package settings
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -12,10 +13,13 @@ import (
 	"time"
 
 	"go.uber.org/zap/zapcore"
+
+	"serv/zok"
 )
 
 var (
 	ErrShowVersion = errors.New("show version")
+	ErrPrintConfig = errors.New("print config")
 	ErrHelp        = flag.ErrHelp
 	LogLevel       zapcore.Level
 	printVersion   bool
@@ -35,6 +39,10 @@ func FlagParse() error {
 	f.Var(&loglevel{}, "log-level", "the level of log messages (debug|info|warn|error|dpanic|panic|fatal)")
 	f.Var(&versionValue{}, "v", "print version")
 	f.Var(&versionValue{}, "version", "print version")
+	var printConfig bool
+	f.Var(&boolValue{p: &printConfig}, "print-config", "print the default config file and exit")
+	var printEffective bool
+	f.Var(&boolValue{p: &printEffective}, "print-effective", "print the settings after applying the config file, environment and flags, and exit")
 
 	m := *Value()
 	if err := loadEnvFlags(f, &m); err != nil {
@@ -57,6 +65,22 @@ func FlagParse() error {
 		return ErrShowVersion
 	}
 
+	if printConfig {
+		if err := writeConfig(os.Stdout, Default); err != nil {
+			return err
+		}
+		return ErrPrintConfig
+	}
+
+	if printEffective {
+		data, err := json.MarshalIndent(m.Redacted(), "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, string(data))
+		return ErrPrintConfig
+	}
+
 	value.Store(&m)
 	return nil
 }
@@ -96,7 +120,8 @@ func loadEnvFlags(flagSet *flag.FlagSet, conf *Settings) error {
 		f := t.Field(i)
 		sf := v.Field(i)
 		def := d.Field(i)
-		usage, _ := structTag(f, "usage")
+		// usage is free text, commas included
+		usage := strings.TrimSpace(f.Tag.Get("usage"))
 		jsonKey, _ := structTag(f, "json")
 		cli, cliOptions := structTag(f, "cli")
 
@@ -166,7 +191,21 @@ func printDefaults(f *flag.FlagSet) {
 	})
 }
 
+var (
+	durationType    = reflect.TypeOf(time.Duration(0))
+	zokDurationType = reflect.TypeOf(zok.Duration(0))
+)
+
 func parseValue(f reflect.Value, s string) (v any, err error) {
+	switch f.Type() {
+	case durationType:
+		return time.ParseDuration(s)
+	case zokDurationType:
+		var d time.Duration
+		d, err = time.ParseDuration(s)
+		return zok.Duration(d), err
+	}
+
 	switch f.Kind().String() {
 	default:
 		err = errors.ErrUnsupported
@@ -215,8 +254,18 @@ func parseValue(f reflect.Value, s string) (v any, err error) {
 		v = float32(n)
 	case "float64":
 		v, err = strconv.ParseFloat(s, 64)
-	case "time.Duration":
-		v, err = time.ParseDuration(s)
+	case "slice":
+		if f.Type().Elem().Kind() != reflect.String {
+			err = errors.ErrUnsupported
+			break
+		}
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v = items
 	}
 	return
 }
@@ -259,14 +308,20 @@ func (i *anyValue) IsBoolFlag() bool {
 }
 
 func (i *anyValue) TypeInfo() string {
+	if t := i.sf.Type(); t == durationType || t == zokDurationType {
+		return "duration"
+	}
 	return i.sf.Type().String()
 }
 
 func (i *anyValue) DefaultValue() string {
 	if i.def.IsValid() && !i.def.IsZero() && i.def.CanInterface() {
 		v := i.def.Interface()
-		if s, ok := v.(string); ok {
-			return strconv.Quote(s)
+		switch v := v.(type) {
+		case string:
+			return strconv.Quote(v)
+		case []string:
+			return strconv.Quote(strings.Join(v, ","))
 		}
 		return fmt.Sprint(v)
 	}
@@ -327,3 +382,32 @@ func (i *versionValue) TypeInfo() string {
 func (i *versionValue) DefaultValue() string {
 	return "false"
 }
+
+type boolValue struct {
+	p *bool
+}
+
+func (i *boolValue) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return strconv.ErrSyntax
+	}
+	*i.p = v
+	return nil
+}
+
+func (i *boolValue) String() string {
+	return i.DefaultValue()
+}
+
+func (i *boolValue) IsBoolFlag() bool {
+	return true
+}
+
+func (i *boolValue) TypeInfo() string {
+	return "bool"
+}
+
+func (i *boolValue) DefaultValue() string {
+	return "false"
+}
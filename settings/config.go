@@ -1,17 +1,29 @@
 package settings
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 const DefaultConfigPath = "config/config.json"
 
+// StdinConfig as CONFIG reads the config from stdin.
+const StdinConfig = "-"
+
 var (
-	configExts = []string{".json"}
+	// configExts are tried in this order and the first existing file wins,
+	// so config.json is used over config.yaml, config.yml and config.toml.
+	configExts = []string{".json", ".yaml", ".yml", ".toml"}
 )
 
 func ConfigPath() string {
@@ -22,44 +34,147 @@ func ConfigPath() string {
 	return DefaultConfigPath
 }
 
+// ConfigCandidates returns the files ReadConfigFile looks for, in order;
+// none for a config not given as a file.
+func ConfigCandidates() []string {
+	if inlineConfig() {
+		return nil
+	}
+	p := filepath.Clean(ConfigPath())
+	dir, name := configBase(p)
+	files := make([]string, 0, len(configExts)+1)
+	if !slices.Contains(configExts, filepath.Ext(p)) {
+		files = append(files, p)
+	}
+	for _, ext := range configExts {
+		files = append(files, filepath.Join(dir, name+ext))
+	}
+	return files
+}
+
+func configBase(filename string) (dir, name string) {
+	p := filepath.Clean(filename)
+	dir, name, ext := filepath.Dir(p), filepath.Base(p), filepath.Ext(p)
+	if len(name) > len(ext) {
+		name = name[:len(name)-len(ext)]
+	}
+	return dir, name
+}
+
 func ReadConfigFile() (config Settings, err error) {
+	if config, ok, err := readInlineConfig(); ok {
+		return config, err
+	}
 	config, _, err = readConfigFile(ConfigPath())
 	return
 }
 
+// inlineConfig reports whether the config comes from CONFIG_JSON,
+// CONFIG_YAML or stdin instead of a file.
+func inlineConfig() bool {
+	_, j := os.LookupEnv("CONFIG_JSON")
+	_, y := os.LookupEnv("CONFIG_YAML")
+	return j || y || ConfigPath() == StdinConfig
+}
+
+var stdin struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// readStdin reads stdin once; the config does not change for reloads.
+func readStdin() ([]byte, error) {
+	stdin.once.Do(func() {
+		stdin.data, stdin.err = io.ReadAll(os.Stdin)
+	})
+	return stdin.data, stdin.err
+}
+
+// readInlineConfig decodes the config of CONFIG_JSON, CONFIG_YAML or stdin,
+// in this order. ok is false when there is none.
+func readInlineConfig() (config Settings, ok bool, err error) {
+	config = defaults()
+	if v, exists := os.LookupEnv("CONFIG_JSON"); exists {
+		return config, true, decodeConfig(".json", []byte(v), &config)
+	}
+	if v, exists := os.LookupEnv("CONFIG_YAML"); exists {
+		return config, true, decodeConfig(".yaml", []byte(v), &config)
+	}
+	if ConfigPath() == StdinConfig {
+		data, err := readStdin()
+		if err != nil {
+			return config, true, err
+		}
+		return config, true, sniffConfig(data, &config)
+	}
+	return config, false, nil
+}
+
+var ErrConfigFormat = errors.New("unknown config format")
+
 func readConfigFile(filename string) (config Settings, path string, err error) {
-	config = Default
+	config = defaults()
 
-	p := filepath.Clean(filename)
-	dir, name, ext := filepath.Dir(p), filepath.Base(p), filepath.Ext(p)
-	if len(name) > len(ext) {
-		name = name[:len(name)-len(ext)]
+	// a file without a known extension, like /etc/serv/config, is sniffed
+	if p := filepath.Clean(filename); !slices.Contains(configExts, filepath.Ext(p)) {
+		if data, err := os.ReadFile(p); err == nil {
+			return config, p, sniffConfig(data, &config)
+		}
 	}
 
+	dir, name := configBase(filename)
+
 	for _, ext := range configExts {
 		target := filepath.Join(dir, name+ext)
-		f, err := os.Open(target)
+		data, err := os.ReadFile(target)
 		if err != nil {
 			continue
 		}
+		return config, target, decodeConfig(ext, data, &config)
+	}
 
-		buf := make([]byte, 4096)
-		n, err := f.Read(buf)
-		if err != nil && !errors.Is(err, io.EOF) {
-			continue
-		}
+	err = os.ErrNotExist
+	return
+}
 
-		switch ext {
-		case ".yml", ".yaml":
-			return config, "", errors.ErrUnsupported
-		case ".json":
-			if err := json.Unmarshal(buf[:n], &config); err != nil {
-				return config, target, err
-			}
-			return config, target, nil
+func decodeConfig(ext string, data []byte, config *Settings) error {
+	switch ext {
+	case ".yml", ".yaml":
+		return yaml.Unmarshal(data, config)
+	case ".toml":
+		// Settings has no toml tags; go through JSON to use its keys
+		var m map[string]any
+		if err := toml.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
 		}
+		return json.Unmarshal(b, config)
+	case ".json":
+		// comments and trailing commas are allowed
+		return json.Unmarshal(stripJSONC(data), config)
 	}
+	return errors.ErrUnsupported
+}
 
-	err = os.ErrNotExist
-	return
+// sniffConfig decodes JSON when data starts with {, otherwise the first of
+// TOML and YAML that decodes.
+func sniffConfig(data []byte, config *Settings) error {
+	if bytes.HasPrefix(bytes.TrimSpace(stripJSONC(data)), []byte("{")) {
+		return decodeConfig(".json", data, config)
+	}
+	var errs []error
+	for _, ext := range []string{".toml", ".yaml"} {
+		c := *config
+		err := decodeConfig(ext, data, &c)
+		if err == nil {
+			*config = c
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("%w (tried json, toml, yaml): %w", ErrConfigFormat, errors.Join(errs...))
 }
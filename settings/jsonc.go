@@ -0,0 +1,66 @@
+package settings
+
+// stripJSONC turns JSON with comments into JSON: // and /* */ comments
+// become spaces, keeping line breaks for error offsets, and commas before
+// a closing } or ] are dropped. Strings are copied verbatim.
+func stripJSONC(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	comma := -1 // index in out of a comma that may be trailing
+
+	for i := 0; i < len(data); i++ {
+		ch := data[i]
+		switch {
+		case ch == '"':
+			comma = -1
+			j := i + 1
+			for ; j < len(data); j++ {
+				if data[j] == '\\' {
+					j++
+				} else if data[j] == '"' {
+					break
+				}
+			}
+			if j >= len(data) {
+				j = len(data) - 1
+			}
+			out = append(out, data[i:j+1]...)
+			i = j
+		case ch == '/' && i+1 < len(data) && data[i+1] == '/':
+			for ; i < len(data) && data[i] != '\n'; i++ {
+				out = append(out, ' ')
+			}
+			if i < len(data) {
+				out = append(out, '\n')
+			}
+		case ch == '/' && i+1 < len(data) && data[i+1] == '*':
+			out = append(out, ' ', ' ')
+			i += 2
+			for ; i < len(data) && !(data[i] == '*' && i+1 < len(data) && data[i+1] == '/'); i++ {
+				if data[i] == '\n' {
+					out = append(out, '\n')
+				} else {
+					out = append(out, ' ')
+				}
+			}
+			if i < len(data) {
+				out = append(out, ' ', ' ')
+				i++
+			}
+		case ch == ',':
+			comma = len(out)
+			out = append(out, ch)
+		case ch == '}' || ch == ']':
+			if comma >= 0 {
+				out[comma] = ' '
+			}
+			comma = -1
+			out = append(out, ch)
+		case ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n':
+			out = append(out, ch)
+		default:
+			comma = -1
+			out = append(out, ch)
+		}
+	}
+	return out
+}
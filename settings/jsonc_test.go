@@ -0,0 +1,88 @@
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const jsoncConfig = `// serv config of the staging host
+{
+	"http": 8080, // behind the load balancer
+	"https": 8443,
+	/* the web root is
+	   relative to data */
+	"data": "/srv/data",
+	"www": "www//dist",
+	"root_redirect": "https://example.com/app/?next=//home", // not a comment
+	"cors_allowed_origins": [
+		"https://a.example.com",
+		// "https://old.example.com",
+		"http://localhost:3000", /* dev */
+	],
+	"headers": {
+		"X-Note": "/* kept */ and // kept",
+		"X-Quote": "say \"//hi\"",
+	},
+	"compress": true,
+}
+`
+
+func TestReadConfigFileJSONC(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(name, []byte(jsoncConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG", name)
+
+	v, err := ReadConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.ServePort != 8080 || v.ServeTLSPort != 8443 || !v.Compress {
+		t.Errorf("http %d, https %d, compress %v", v.ServePort, v.ServeTLSPort, v.Compress)
+	}
+	if v.DataDirectory != "/srv/data" || v.WebRoot != "www//dist" {
+		t.Errorf("data %q, www %q", v.DataDirectory, v.WebRoot)
+	}
+	if want := "https://example.com/app/?next=//home"; v.RootRedirect != want {
+		t.Errorf("root_redirect = %q, want %q", v.RootRedirect, want)
+	}
+	if want := []string{"https://a.example.com", "http://localhost:3000"}; !slices.Equal(v.CORSAllowedOrigins, want) {
+		t.Errorf("cors_allowed_origins = %q, want %q", v.CORSAllowedOrigins, want)
+	}
+	if got := v.Headers["X-Note"]; got != "/* kept */ and // kept" {
+		t.Errorf("X-Note = %q", got)
+	}
+	if got := v.Headers["X-Quote"]; got != `say "//hi"` {
+		t.Errorf("X-Quote = %q", got)
+	}
+}
+
+func TestStripJSONC(t *testing.T) {
+	out := stripJSONC([]byte(jsoncConfig))
+	if !json.Valid(out) {
+		t.Fatalf("not JSON:\n%s", out)
+	}
+	// error offsets still point at the same line
+	if got, want := bytes.Count(out, []byte("\n")), bytes.Count([]byte(jsoncConfig), []byte("\n")); got != want {
+		t.Errorf("%d lines, want %d", got, want)
+	}
+
+	tests := []struct{ in, want string }{
+		{`{"a": 1}`, `{"a": 1}`},
+		{`{"a": "http://x"}`, `{"a": "http://x"}`},
+		{`{"a": "\\"} // c`, `{"a": "\\"}     `},
+		{`[1, 2,]`, `[1, 2 ]`},
+		{`[1, /* , */ 2]`, `[1,         2]`},
+		{`{"a": [1,], }`, `{"a": [1 ]  }`},
+	}
+	for _, tt := range tests {
+		if got := string(stripJSONC([]byte(tt.in))); got != tt.want {
+			t.Errorf("stripJSONC(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// writeConfig writes v as a config file, each key preceded by its usage as
+// a comment. readConfigFile accepts the comments.
+func writeConfig(w io.Writer, v Settings) error {
+	var b bytes.Buffer
+	b.WriteString("{\n")
+
+	t := reflect.TypeOf(v)
+	rv := reflect.ValueOf(v)
+	first := true
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key, _ := structTag(f, "json")
+		if key == "" || key == "-" {
+			continue
+		}
+		data, err := json.MarshalIndent(rv.Field(i).Interface(), "  ", "  ")
+		if err != nil {
+			return err
+		}
+		if !first {
+			b.WriteString(",\n")
+		}
+		first = false
+		if usage := strings.TrimSpace(f.Tag.Get("usage")); usage != "" {
+			b.WriteString("  // " + usage + "\n")
+		}
+		b.WriteString("  " + string(mustMarshal(key)) + ": ")
+		b.Write(data)
+	}
+	b.WriteString("\n}\n")
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func mustMarshal(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
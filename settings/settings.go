@@ -1,28 +1,244 @@
 package settings
 
 import (
+	"reflect"
 	"sync/atomic"
+	"time"
+
+	"serv/zok"
 )
 
 type Settings struct {
-	ServePort      int    `json:"http" yaml:"http" usage:"server port"`
-	ServeTLSPort   int    `json:"https" yaml:"https"`
+	ServePort    int    `json:"http" yaml:"http" usage:"server port"`
+	ServeTLSPort int    `json:"https" yaml:"https"`
+	Network      string `json:"network" yaml:"network" usage:"listen network: tcp, tcp4, tcp6 or dual (separate tcp4 and tcp6 listeners)"`
+	BindAddress  string `json:"bind_address" yaml:"bind_address" usage:"address of the http and https listeners (empty listens on all interfaces)"`
+
+	// UnixSocket replaces the TCP listener on the http port.
+	UnixSocket     string `json:"unix_socket" yaml:"unix_socket" usage:"serve http on this unix socket instead of the http port"`
+	UnixSocketMode string `json:"unix_socket_mode" yaml:"unix_socket_mode" usage:"octal permissions of the unix socket"`
 	TLSCertificate string `json:"tls_cert" yaml:"tls_cert"`
 	TLSKey         string `json:"tls_key" yaml:"tls_key"`
-	TLSPfx         string `json:"tls_pfx" yaml:"tls_pfx"`
+	TLSPfx         string `json:"tls_pfx" yaml:"tls_pfx" usage:"PKCS#12 file with the certificate and key, instead of tls-cert and tls-key"`
+
+	TLSPfxPassphrase string `json:"tls_pfx_passphrase" yaml:"tls_pfx_passphrase" usage:"passphrase of tls-pfx"`
+
+	// TLSHosts maps SNI host names, or wildcards like *.example.com, to
+	// their certificate. Other names get the certificate above.
+	TLSHosts map[string]TLSPair `json:"tls_hosts" yaml:"tls_hosts" cli:",ignored"`
+
+	// TLSClientCA requires clients of the https listener to present a
+	// certificate signed by one of these CAs.
+	TLSClientCA   string `json:"tls_client_ca" yaml:"tls_client_ca" usage:"PEM bundle of the CAs client certificates must be signed by"`
+	TLSClientAuth string `json:"tls_client_auth" yaml:"tls_client_auth" usage:"with tls-client-ca: require a client certificate, or verify only those given"`
+
+	// ACMEDomains obtains and renews certificates for these domains from
+	// Let's Encrypt, in place of the certificate settings above. The http
+	// port must be reachable for the http-01 challenge.
+	ACMEDomains []string `json:"acme_domains" yaml:"acme_domains" usage:"comma-separated domains to obtain certificates for from Let's Encrypt"`
+	ACMEEmail   string   `json:"acme_email" yaml:"acme_email" usage:"contact email of the ACME account"`
+
+	// PEM contents, an alternative to TLSCertificate and TLSKey that keeps
+	// secrets off the disk. These are not watched; reload on config change.
+	TLSCertificatePEM string `json:"tls_cert_pem" yaml:"tls_cert_pem" usage:"PEM encoded certificate, instead of tls-cert"`
+	TLSKeyPEM         string `json:"tls_key_pem" yaml:"tls_key_pem" usage:"PEM encoded private key, instead of tls-key"`
+
+	// AdminPort moves the /vapi routes to a listener of their own, off the
+	// public ports.
+	AdminBind string `json:"admin_bind" yaml:"admin_bind" usage:"address of the admin listener"`
+	AdminPort int    `json:"admin_port" yaml:"admin_port" usage:"serve the /vapi routes only on this port (0 serves them on the public ports)"`
+
+	RedirectToHTTPS bool `json:"redirect_to_https" yaml:"redirect_to_https" usage:"redirect GET and HEAD requests on the http port to https when TLS is configured"`
+
+	// HSTSMaxAge sends Strict-Transport-Security with responses over https.
+	HSTSMaxAge            int  `json:"hsts_max_age" yaml:"hsts_max_age" usage:"max-age in seconds of the Strict-Transport-Security header of https responses (0 disables)"`
+	HSTSIncludeSubDomains bool `json:"hsts_include_subdomains" yaml:"hsts_include_subdomains" usage:"add includeSubDomains to Strict-Transport-Security"`
+	HSTSPreload           bool `json:"hsts_preload" yaml:"hsts_preload" usage:"add preload to Strict-Transport-Security"`
+
+	// ContentSecurityPolicy is sent with every HTML response.
+	ContentSecurityPolicy           string `json:"content_security_policy" yaml:"content_security_policy" usage:"Content-Security-Policy of HTML responses"`
+	ContentSecurityPolicyReportOnly string `json:"content_security_policy_report_only" yaml:"content_security_policy_report_only" usage:"Content-Security-Policy-Report-Only of HTML responses"`
+
+	KeepAlive bool `json:"keep_alive" yaml:"keep_alive" usage:"enable HTTP keep-alives"`
+
+	APITLSOnly bool `json:"api_tls_only" yaml:"api_tls_only" usage:"serve the /vapi routes on the https listener only"`
+
+	// Basic authentication of every route, or of the /vapi routes only with
+	// BasicAuthScope api. BasicAuthFile is htpasswd style, with bcrypt
+	// (htpasswd -B) or plain text passwords; users of other hashes, such as
+	// the $apr1$ default of htpasswd, are refused.
+	BasicAuthUser     string `json:"basic_auth_user" yaml:"basic_auth_user" usage:"user name required by Basic authentication"`
+	BasicAuthPassword string `json:"basic_auth_password" yaml:"basic_auth_password" usage:"password of basic-auth-user"`
+	BasicAuthFile     string `json:"basic_auth_file" yaml:"basic_auth_file" usage:"htpasswd file with the users of Basic authentication, bcrypt (htpasswd -B) or plain text passwords"`
+	BasicAuthScope    string `json:"basic_auth_scope" yaml:"basic_auth_scope" usage:"routes requiring Basic authentication: all or api"`
+
+	// CORS of the /vapi routes, off while CORSAllowedOrigins is empty.
+	CORSAllowedOrigins   []string `json:"cors_allowed_origins" yaml:"cors_allowed_origins" usage:"comma-separated origins allowed to call /vapi, * for any"`
+	CORSAllowedMethods   []string `json:"cors_allowed_methods" yaml:"cors_allowed_methods" usage:"comma-separated methods allowed for cross-origin requests"`
+	CORSAllowedHeaders   []string `json:"cors_allowed_headers" yaml:"cors_allowed_headers" usage:"comma-separated request headers allowed for cross-origin requests"`
+	CORSAllowCredentials bool     `json:"cors_allow_credentials" yaml:"cors_allow_credentials" usage:"allow cross-origin requests with credentials from the origins listed by name"`
+
+	// TrustedProxies are the addresses or CIDRs whose X-Forwarded-For is
+	// believed for the client IP of logs and rate limits.
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies" usage:"comma-separated proxy addresses or CIDRs trusted for X-Forwarded-For"`
+
+	// RateLimit is a token bucket per client IP, 0 disables it.
+	RateLimit float64 `json:"rate_limit" yaml:"rate_limit" usage:"requests a second allowed per client IP (0 disables)"`
+	RateBurst int     `json:"rate_burst" yaml:"rate_burst" usage:"requests a client IP may send at once beyond rate-limit (default rate-limit, at least 1)"`
+
+	// ShutdownTimeout is how long the requests in flight may run when the
+	// server stops or restarts.
+	ShutdownTimeout zok.Duration `json:"shutdown_timeout" yaml:"shutdown_timeout" usage:"let requests in flight finish for this long when stopping (0 closes them at once)"`
+
+	// ReloadGrace holds back restarts for config changes made this soon
+	// after startup; they are applied together once it has passed.
+	ReloadGrace zok.Duration `json:"reload_grace" yaml:"reload_grace" usage:"delay restarts for config changes during this period after startup"`
 
 	WebRoot       string `json:"www" yaml:"www"`
 	DataDirectory string `json:"data" yaml:"data"`
+
+	// IndexFile is the index document of directories and, with SPAFallback,
+	// served for GET requests of missing files.
+	IndexFile   string `json:"index_file" yaml:"index_file" usage:"index document of directories and the SPA fallback"`
+	SPAFallback bool   `json:"spa_fallback" yaml:"spa_fallback" usage:"serve the index for GET requests of missing files instead of 404"`
+
+	// AutoIndex lists the files of directories without an index document.
+	AutoIndex bool `json:"auto_index" yaml:"auto_index" usage:"list the files of directories without an index document"`
+
+	// IndexFiles maps URL path prefixes to the index document used for
+	// directories and the SPA fallback below them; the longest prefix wins.
+	IndexFiles map[string]string `json:"index_files" yaml:"index_files" cli:",ignored"`
+
+	// DenyDotfiles returns 404 for paths with a component starting with a
+	// dot, except below the DotfilesAllow prefixes.
+	DenyDotfiles  bool     `json:"deny_dotfiles" yaml:"deny_dotfiles" usage:"return 404 for paths with a component starting with a dot"`
+	DotfilesAllow []string `json:"dotfiles_allow" yaml:"dotfiles_allow" usage:"comma-separated path prefixes exempt from deny-dotfiles"`
+
+	// NegotiateLanguage serves name.<lang>.ext instead of name.ext when the
+	// variant exists for a language of Accept-Language.
+	NegotiateLanguage bool `json:"negotiate_language" yaml:"negotiate_language" usage:"serve language variants of files such as index.en.html by Accept-Language"`
+
+	// SingleFile is served for every GET request, ignoring the directory
+	// structure of the web root.
+	SingleFile string `json:"single_file" yaml:"single_file" usage:"file of the web root served for every GET request"`
+
+	// Precompressed serves name.zst or name.gz, when present next to name,
+	// instead of compressing name on the fly.
+	Precompressed bool `json:"precompressed" yaml:"precompressed" usage:"serve precompressed .zst and .gz files next to the requested file"`
+
+	// CacheControl maps glob patterns to the Cache-Control of the static
+	// files they match; the longest matching pattern wins. Patterns with a
+	// slash match the path, like /assets/*.js, others the base name, like
+	// *.html. Unmatched files revalidate on every request.
+	CacheControl map[string]string `json:"cache_control" yaml:"cache_control" cli:",ignored"`
+
+	// WeakETag derives ETags from the size and modification time of files
+	// instead of an md5 of their content.
+	WeakETag bool `json:"weak_etag" yaml:"weak_etag" usage:"use ETags from file size and modification time instead of content hashes"`
+
+	// RootRedirect and RootDocument change what exactly / serves, before the
+	// index of the web root and the SPA fallback are considered.
+	RootRedirect string `json:"root_redirect" yaml:"root_redirect" usage:"redirect / to this URL, e.g. /app/"`
+	RootDocument string `json:"root_document" yaml:"root_document" usage:"file of the web root served for /"`
+
+	// MimeTypes is an nginx style mime.types file, loaded on every (re)start.
+	// Mappings removed from the file stay registered until the process exits.
+	MimeTypes string `json:"mime_types" yaml:"mime_types" usage:"mime.types file with extra extension to media type mappings"`
+
+	ErrorPage        string   `json:"error_page" yaml:"error_page" usage:"HTML document in the web root served for 5xx errors of static files"`
+	NotFoundPage     string   `json:"not_found_page" yaml:"not_found_page" usage:"HTML document in the web root served with status 404 for missing static files"`
+	RobotsTxt        string   `json:"robots_txt" yaml:"robots_txt" usage:"robots.txt content served when the web root has none"`
+	NotFoundPrefixes []string `json:"not_found_prefixes" yaml:"not_found_prefixes" usage:"comma-separated path prefixes that return 404 instead of the SPA index"`
+
+	Debug bool `json:"debug" yaml:"debug" usage:"enable the debugging endpoints"`
+
+	// BodyLog logs this many bytes of /vapi request and response bodies
+	// when Debug is set.
+	BodyLog              int      `json:"body_log" yaml:"body_log" usage:"with debug, log up to this many bytes of /vapi request and response bodies"`
+	BodyLogRedact        []string `json:"body_log_redact" yaml:"body_log_redact" usage:"comma-separated path prefixes whose bodies are never logged"`
+	BodyLogRedactHeaders []string `json:"body_log_redact_headers" yaml:"body_log_redact_headers" usage:"comma-separated request headers logged as redacted, besides Authorization and Cookie"`
+
+	Compress bool `json:"compress" yaml:"compress" usage:"compress responses"`
+
+	CompressDebug  bool `json:"compress_debug" yaml:"compress_debug" usage:"explain the compression decision in an X-Compression response header"`
+	CompressDigest bool `json:"compress_digest" yaml:"compress_digest" usage:"send a SHA-256 trailer of the uncompressed body with compressed responses"`
+
+	CompressMinSize int    `json:"compress_min_size" yaml:"compress_min_size" usage:"leave responses of at most this many bytes uncompressed"`
+	CompressLevel   string `json:"compress_level" yaml:"compress_level" usage:"compression level: fast, default or best"`
+
+	// CompressPolicy maps file extensions of static files to force, skip or
+	// auto. Auto compresses a sample of the file and skips compression when
+	// it does not shrink to CompressAutoRatio of its size.
+	CompressPolicy    map[string]string `json:"compress_policy" yaml:"compress_policy" cli:",ignored"`
+	CompressAutoRatio float64           `json:"compress_auto_ratio" yaml:"compress_auto_ratio" usage:"largest compressed to original size ratio worth compressing under the auto policy"`
+
+	// Headers are added to every response; those with an empty value are
+	// removed instead. Headers of static files like ETag and Cache-Control
+	// take precedence.
+	Headers map[string]string `json:"headers" yaml:"headers" cli:",ignored"`
+
+	SlowRequest zok.Duration `json:"slow_request" yaml:"slow_request" usage:"warn about requests taking longer than this (0 disables)"`
+
+	// LogFile moves the log from stdout into a file, rotated by the
+	// settings below, which do nothing without it.
+	LogFile string `json:"log_file" yaml:"log_file" usage:"write the log to this file instead of stdout"`
+
+	// Rotation of the log file; zero values keep the defaults.
+	LogMaxSize    int          `json:"log_max_size" yaml:"log_max_size" usage:"rotate the log file at this size in bytes (default 4 MiB)"`
+	LogMaxBackups int          `json:"log_max_backups" yaml:"log_max_backups" usage:"rotated log files to keep (default 6)"`
+	LogMaxAge     zok.Duration `json:"log_max_age" yaml:"log_max_age" usage:"remove rotated log files older than this (0 keeps them)"`
+	LogCompress   bool         `json:"log_compress" yaml:"log_compress" usage:"gzip rotated log files"`
+
+	LogSyncInterval zok.Duration `json:"log_sync_interval" yaml:"log_sync_interval" usage:"sync log_file this often (0 syncs on exit only)"`
+
+	AccessLog             bool `json:"access_log" yaml:"access_log" usage:"log every request"`
+	AccessLogSample       int  `json:"access_log_sample" yaml:"access_log_sample" usage:"log one of every N requests (0 or 1 logs all)"`
+	AccessLogSampleErrors bool `json:"access_log_sample_errors" yaml:"access_log_sample_errors" usage:"apply sampling to non-2xx responses too"`
+
+	// AccessLogFormat is json, a record of the log, or the common or
+	// combined log format of Apache. AccessLogFile moves the access log
+	// out of the log into its own file, rotated like it.
+	AccessLogFormat string `json:"access_log_format" yaml:"access_log_format" usage:"access log format: json, common or combined"`
+	AccessLogFile   string `json:"access_log_file" yaml:"access_log_file" usage:"write the access log to this file instead of the log"`
+}
+
+type TLSPair struct {
+	Cert string `json:"cert" yaml:"cert"`
+	Key  string `json:"key" yaml:"key"`
 }
 
 var (
 	Version   string
 	BuildTime string
 	Default   = Settings{
-		ServePort:     80,
-		ServeTLSPort:  443,
-		WebRoot:       "www",
-		DataDirectory: "data",
+		ServePort:          80,
+		ServeTLSPort:       443,
+		Network:            "tcp",
+		UnixSocketMode:     "0660",
+		TLSClientAuth:      "require",
+		AdminBind:          "127.0.0.1",
+		KeepAlive:          true,
+		ShutdownTimeout:    zok.Duration(10 * time.Second),
+		BasicAuthScope:     "all",
+		CORSAllowedMethods: []string{"GET", "POST", "DELETE"},
+		CORSAllowedHeaders: []string{"Content-Type", "Authorization"},
+		WebRoot:            "www",
+		DataDirectory:      "data",
+		IndexFile:          "index.html",
+		SPAFallback:        true,
+		RobotsTxt:          "User-agent: *\nDisallow:\n",
+		NotFoundPrefixes:   []string{"/.well-known/", "/api/"},
+		DenyDotfiles:       true,
+		DotfilesAllow:      []string{"/.well-known/"},
+		Compress:           true,
+		Precompressed:      true,
+		CompressAutoRatio:  0.9,
+		CompressMinSize:    1024,
+		CompressLevel:      "default",
+		SlowRequest:        zok.Duration(10 * time.Second),
+		LogCompress:        true,
+		LogSyncInterval:    zok.Duration(5 * time.Second),
+		AccessLogFormat:    "json",
 	}
 )
 
@@ -30,12 +246,59 @@ var (
 	value atomic.Value
 )
 
+// defaults returns a copy of Default that shares none of its slices and
+// maps, so that decoding a config into it leaves Default as it is.
+func defaults() Settings {
+	d := Default
+	v := reflect.ValueOf(&d).Elem()
+	for i := range v.NumField() {
+		f := v.Field(i)
+		if f.IsZero() {
+			continue
+		}
+		switch f.Kind() {
+		case reflect.Slice:
+			f.Set(reflect.AppendSlice(reflect.MakeSlice(f.Type(), 0, f.Len()), f))
+		case reflect.Map:
+			m := reflect.MakeMapWithSize(f.Type(), f.Len())
+			for it := f.MapRange(); it.Next(); {
+				m.SetMapIndex(it.Key(), it.Value())
+			}
+			f.Set(m)
+		}
+	}
+	return d
+}
+
 func Load() error {
-	m, _, err := readConfigFile(ConfigPath())
+	m, err := ReadConfigFile()
 	value.Store(&m)
 	return err
 }
 
+// Store replaces the current settings, e.g. to put back the last good
+// settings after a failed Load.
+func Store(v Settings) {
+	value.Store(&v)
+}
+
+// Redacted returns a copy of s with the secrets replaced, for printing or
+// responding the settings.
+func (s Settings) Redacted() Settings {
+	for _, v := range []*string{&s.TLSKeyPEM, &s.TLSPfxPassphrase, &s.BasicAuthPassword} {
+		if *v != "" {
+			*v = "(redacted)"
+		}
+	}
+	return s
+}
+
+// Value returns the current settings, or a copy of Default when neither
+// Load nor FlagParse has stored any yet.
 func Value() *Settings {
-	return value.Load().(*Settings)
+	if v, ok := value.Load().(*Settings); ok {
+		return v
+	}
+	d := defaults()
+	return &d
 }
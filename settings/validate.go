@@ -0,0 +1,125 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path"
+	"strconv"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// Validate reports every setting that would keep the server from working.
+func (s *Settings) Validate() error {
+	var errs []error
+
+	port := func(name string, v int, optional bool) {
+		if optional && v == 0 {
+			return
+		}
+		if v < 1 || v > 65535 {
+			errs = append(errs, fmt.Errorf("%s: port %d out of range 1-65535", name, v))
+		}
+	}
+	port("http", s.ServePort, false)
+	port("https", s.ServeTLSPort, false)
+	port("admin_port", s.AdminPort, true)
+
+	switch s.Network {
+	case "", "tcp", "tcp4", "tcp6", "dual":
+	default:
+		errs = append(errs, fmt.Errorf("network: unsupported %q", s.Network))
+	}
+
+	if s.BindAddress != "" && net.ParseIP(s.BindAddress) == nil {
+		if _, err := net.LookupHost(s.BindAddress); err != nil {
+			errs = append(errs, fmt.Errorf("bind_address: %w", err))
+		}
+	}
+
+	if s.UnixSocket != "" {
+		if _, err := strconv.ParseUint(s.UnixSocketMode, 8, 32); err != nil {
+			errs = append(errs, fmt.Errorf("unix_socket_mode: %q is not an octal mode", s.UnixSocketMode))
+		}
+	}
+
+	if fi, err := os.Stat(s.DataDirectory); err != nil {
+		errs = append(errs, fmt.Errorf("data: %w", err))
+	} else if !fi.IsDir() {
+		errs = append(errs, fmt.Errorf("data: %s is not a directory", s.DataDirectory))
+	}
+
+	if (s.TLSCertificate == "") != (s.TLSKey == "") {
+		errs = append(errs, errors.New("tls_cert and tls_key must be set together"))
+	}
+	if (s.TLSCertificatePEM == "") != (s.TLSKeyPEM == "") {
+		errs = append(errs, errors.New("tls_cert_pem and tls_key_pem must be set together"))
+	}
+	for name, pair := range s.TLSHosts {
+		if pair.Cert == "" || pair.Key == "" {
+			errs = append(errs, fmt.Errorf("tls_hosts: %s needs both cert and key", name))
+		}
+	}
+	if s.TLSPfx != "" && (s.TLSCertificate != "" || s.TLSKey != "") {
+		errs = append(errs, errors.New("tls_pfx and tls_cert/tls_key are mutually exclusive"))
+	}
+
+	for pattern := range s.CacheControl {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("cache_control: %q: %w", pattern, err))
+		}
+	}
+
+	for name, value := range s.Headers {
+		if !httpguts.ValidHeaderFieldName(name) {
+			errs = append(errs, fmt.Errorf("headers: invalid name %q", name))
+		} else if !httpguts.ValidHeaderFieldValue(value) {
+			errs = append(errs, fmt.Errorf("headers: invalid value of %s", name))
+		}
+	}
+
+	switch s.CompressLevel {
+	case "", "fast", "default", "best":
+	default:
+		errs = append(errs, fmt.Errorf("compress_level: unsupported %q, want fast, default or best", s.CompressLevel))
+	}
+
+	switch s.AccessLogFormat {
+	case "", "json", "common", "combined":
+	default:
+		errs = append(errs, fmt.Errorf("access_log_format: unsupported %q, want json, common or combined", s.AccessLogFormat))
+	}
+
+	for _, v := range s.TrustedProxies {
+		if _, _, err := net.ParseCIDR(v); err != nil && net.ParseIP(v) == nil {
+			errs = append(errs, fmt.Errorf("trusted_proxies: %q is not an IP address or CIDR", v))
+		}
+	}
+	if s.RateLimit < 0 || math.IsNaN(s.RateLimit) {
+		errs = append(errs, fmt.Errorf("rate_limit: %v must not be negative", s.RateLimit))
+	}
+
+	switch s.BasicAuthScope {
+	case "", "all", "api":
+	default:
+		errs = append(errs, fmt.Errorf("basic_auth_scope: unsupported %q, want all or api", s.BasicAuthScope))
+	}
+	if s.BasicAuthPassword != "" && s.BasicAuthUser == "" {
+		errs = append(errs, errors.New("basic_auth_password needs basic_auth_user"))
+	}
+
+	switch s.TLSClientAuth {
+	case "", "require", "verify":
+	default:
+		errs = append(errs, fmt.Errorf("tls_client_auth: unsupported %q, want require or verify", s.TLSClientAuth))
+	}
+
+	if s.RootRedirect != "" && s.RootDocument != "" {
+		errs = append(errs, errors.New("root_redirect and root_document are mutually exclusive"))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"serv/server"
+	"serv/settings"
+	"serv/zok/log"
+)
+
+// supervisor runs the server with the current settings and restarts it
+// when the config file, or a file it names, changes.
+type supervisor struct {
+	// flags applies the command line over a reloaded config.
+	flags func() error
+	// debounce is how long changes of the watched files settle before a
+	// reload.
+	debounce time.Duration
+	// started is when the process started; changes within ReloadGrace of
+	// it are applied once the grace is over.
+	started time.Time
+	// logging are the options the log was opened with.
+	logging log.Options
+}
+
+// run serves until ctx is done, then waits for the server to stop. The
+// cause of ctx is the reason of the stop.
+func (sv *supervisor) run(ctx context.Context) error {
+	var ch = make(chan InotifyEvent, 1)
+	var changed = make(chan struct{}, 1)
+	var certChanged = make(chan struct{}, 1)
+
+	f := NewINotify()
+	if err := f.Open(); err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, name := range settings.ConfigCandidates() {
+		if err := f.AddWatch(name, Remove|Rename|Create|CloseWrite); err != nil {
+			return err
+		}
+	}
+
+	// changes of the certificate files are applied in place, see certs
+	var certFiles []string
+	if settings.Value().TLSCertificate != "" || settings.Value().TLSKey != "" {
+		certFiles = append(certFiles, settings.Value().TLSCertificate, settings.Value().TLSKey)
+	}
+	if settings.Value().TLSPfx != "" {
+		certFiles = append(certFiles, settings.Value().TLSPfx)
+	}
+	for _, pair := range settings.Value().TLSHosts {
+		certFiles = append(certFiles, pair.Cert, pair.Key)
+	}
+	for i, name := range certFiles {
+		// hosts may share a certificate
+		if err := f.AddWatch(name, Remove|Rename|Create|CloseWrite); err != nil && !errors.Is(err, ErrWatched) {
+			return err
+		}
+		certFiles[i] = filepath.Clean(name)
+	}
+
+	// a new client CA or htpasswd file restarts the server
+	for _, name := range []string{settings.Value().TLSClientCA, settings.Value().BasicAuthFile} {
+		if name == "" {
+			continue
+		}
+		if err := f.AddWatch(name, Remove|Rename|Create|CloseWrite); err != nil && !errors.Is(err, ErrWatched) {
+			return err
+		}
+	}
+
+	sums := fileSums{}
+	hash := digest(f, sums)
+
+	go f.Watch(ch)
+
+	go func() {
+		var config, cert *time.Timer
+
+		for e := range ch {
+			if slices.Contains(certFiles, filepath.Join(e.Path, e.Name)) {
+				cert = debounce(cert, sv.debounce, certChanged)
+			} else {
+				config = debounce(config, sv.debounce, changed)
+			}
+		}
+	}()
+
+	var srvCtx, cancel = context.WithCancelCause(ctx)
+	srv := make(chan context.Context, 1)
+	srv <- srvCtx
+
+	var wg = &sync.WaitGroup{}
+	var restart server.Restart
+	var prev context.Context
+	var events = make(chan server.Event, 16)
+	go func() {
+		for e := range events {
+			log.Debugw("lifecycle", "event", e.Kind, "listener", e.Listener, "addr", e.Addr, "err", e.Err)
+		}
+	}()
+	var grace = sv.started.Add(settings.Value().ReloadGrace.Value())
+	// certs is the certificate of the running server when it comes from
+	// files; nil otherwise or when it failed to load.
+	var certs *server.Certificate
+	var deferred bool
+
+	// reload reads the settings again and restarts the server if they changed.
+	// A config that does not parse or validate leaves the last good
+	// settings and the running server untouched.
+	reload := func() (bool, error) {
+		good := *settings.Value()
+		if err := settings.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			settings.Store(good)
+			return false, fmt.Errorf("config not reloaded: %w", err)
+		}
+		if err := sv.flags(); err != nil {
+			settings.Store(good)
+			return false, fmt.Errorf("config not reloaded: %w", err)
+		}
+		if err := settings.Value().Validate(); err != nil {
+			settings.Store(good)
+			return false, fmt.Errorf("invalid settings, not reloaded: %w", err)
+		}
+
+		b := digest(f, sums)
+
+		if bytes.Equal(hash, b) {
+			return false, nil
+		}
+
+		hash = b
+
+		if o := logOptions(settings.Value()); o != sv.logging {
+			sv.logging = o
+			if err := log.Reconfigure(o); err != nil {
+				log.Error(err)
+			}
+		}
+
+		server.Emit(events, server.Event{Kind: server.EventReloading, Err: ErrConfigChanged})
+		cancel(ErrConfigChanged)
+		srvCtx, cancel = context.WithCancelCause(ctx)
+		srv <- srvCtx
+		return true, nil
+	}
+
+	var reloads = make(chan chan<- reloadResult)
+	requestReload := func(ctx context.Context) (bool, settings.Settings, error) {
+		reply := make(chan reloadResult, 1)
+		select {
+		case reloads <- reply:
+		case <-ctx.Done():
+			return false, settings.Settings{}, ctx.Err()
+		}
+		r := <-reply
+		return r.changed, r.settings, r.err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// the server stops with the cause of ctx
+			wg.Wait()
+			return nil
+		case next := <-srv:
+			if prev != nil {
+				restart.Count++
+				restart.Time = time.Now()
+				if err := context.Cause(prev); err != nil {
+					restart.Reason = err.Error()
+				}
+			}
+			prev = next
+			certs = nil
+			if load := server.CertificateLoader(settings.Value()); load != nil {
+				// a failure is reported by the https listener
+				certs, _ = server.NewCertificate(load)
+			}
+			wg.Add(1)
+			go func(ctx context.Context, restart server.Restart, certs *server.Certificate) {
+				defer wg.Done()
+				server.New(server.ServerConfig{
+					Settings:    *settings.Value(),
+					Version:     settings.Version,
+					Logger:      log.Default(),
+					Started:     sv.started,
+					Restart:     restart,
+					Events:      events,
+					Reload:      requestReload,
+					Certificate: certs,
+				}).Run(ctx)
+				err := context.Cause(ctx)
+				if errors.Is(err, ErrTerminated) {
+					log.Error(err)
+				} else if errors.Is(err, ErrConfigChanged) {
+					//
+				} else if err != nil {
+					log.Info("server restart because:", err.Error())
+				}
+			}(next, restart, certs)
+		case <-changed:
+			if d := time.Until(grace); d > 0 {
+				if !deferred {
+					deferred = true
+					log.Info("config changed during startup, reload in", d.Round(time.Millisecond).String())
+					time.AfterFunc(d, func() { changed <- struct{}{} })
+				}
+				continue
+			}
+
+			if _, err := reload(); err != nil {
+				log.Error(err)
+			}
+		case <-certChanged:
+			if certs != nil {
+				err := certs.Reload()
+				if err == nil {
+					// keep a later config reload from restarting for it
+					hash = digest(f, sums)
+					log.Info("certificate reloaded")
+					continue
+				}
+				log.Error(fmt.Errorf("certificate not reloaded: %w", err))
+			}
+			if _, err := reload(); err != nil {
+				log.Error(err)
+			}
+		case reply := <-reloads:
+			changed, err := reload()
+			reply <- reloadResult{changed, *settings.Value(), err}
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"serv/server"
+	"serv/settings"
+)
+
+const testDebounce = 100 * time.Millisecond
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func writeTestFile(t *testing.T, name, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestConfig(t *testing.T, name string, v map[string]any) {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, name, string(data))
+}
+
+// get requests url until the server answers, which it does once it
+// listens.
+func get(t *testing.T, url string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err := http.Get(url)
+		if err == nil {
+			defer res.Body.Close()
+			b, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(b)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// closed waits for nothing to listen on port any more.
+func closed(t *testing.T, port int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+		if err != nil {
+			return
+		}
+		c.Close()
+		if time.Now().After(deadline) {
+			t.Fatalf("port %d is still open", port)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func restarts(t *testing.T, port int) server.Restart {
+	t.Helper()
+	var v struct {
+		Restarts server.Restart `json:"restarts"`
+	}
+	if err := json.Unmarshal([]byte(get(t, fmt.Sprintf("http://127.0.0.1:%d/vapi/status", port))), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v.Restarts
+}
+
+// startSupervisor loads the config at name and runs a supervisor until the
+// test ends.
+func startSupervisor(t *testing.T, name string) (stop func()) {
+	t.Helper()
+	t.Setenv("CONFIG", name)
+	if err := settings.Load(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { settings.Store(settings.Default) })
+
+	sv := &supervisor{
+		flags:    func() error { return nil },
+		debounce: testDebounce,
+		started:  time.Now(),
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- sv.run(ctx) }()
+
+	stop = func() {
+		cancel(ErrTerminated)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("the supervisor did not stop")
+		}
+	}
+	t.Cleanup(func() {
+		select {
+		case <-ctx.Done():
+		default:
+			stop()
+		}
+	})
+	return stop
+}
+
+func TestSupervisorReload(t *testing.T) {
+	dir := t.TempDir()
+	for _, www := range []string{"one", "two"} {
+		writeTestFile(t, filepath.Join(dir, www, "index.html"), www)
+	}
+	config := filepath.Join(dir, "config.json")
+	p1, p2 := freePort(t), freePort(t)
+	writeTestConfig(t, config, map[string]any{"http": p1, "data": dir, "www": "one"})
+
+	stop := startSupervisor(t, config)
+	if got := get(t, fmt.Sprintf("http://127.0.0.1:%d/", p1)); got != "one" {
+		t.Fatalf("before the change: got %q, want %q", got, "one")
+	}
+
+	// saves within the debounce restart the server once, with the last
+	for _, www := range []string{"one", "two", "two"} {
+		writeTestConfig(t, config, map[string]any{"http": p2, "data": dir, "www": www})
+		time.Sleep(testDebounce / 10)
+	}
+	if got := get(t, fmt.Sprintf("http://127.0.0.1:%d/", p2)); got != "two" {
+		t.Fatalf("after the change: got %q, want %q", got, "two")
+	}
+	closed(t, p1)
+	if r := restarts(t, p2); r.Count != 1 || r.Reason != ErrConfigChanged.Error() {
+		t.Fatalf("restarts = %+v, want 1 for the config change", r)
+	}
+
+	// a broken config keeps the last good settings and the running server
+	writeTestFile(t, config, `{"http": `)
+	time.Sleep(4 * testDebounce)
+	if got := get(t, fmt.Sprintf("http://127.0.0.1:%d/", p2)); got != "two" {
+		t.Fatalf("after a broken config: got %q, want %q", got, "two")
+	}
+	if v := settings.Value(); v.ServePort != p2 || v.WebRoot != "two" {
+		t.Fatalf("after a broken config: http %d, www %q", v.ServePort, v.WebRoot)
+	}
+
+	// saving the same settings again, even formatted differently, does
+	// not restart
+	writeTestFile(t, config, fmt.Sprintf("{\n  \"www\": \"two\",\n  \"data\": %q,\n  \"http\": %d\n}\n", dir, p2))
+	time.Sleep(4 * testDebounce)
+	if r := restarts(t, p2); r.Count != 1 {
+		t.Fatalf("restarts = %d after the same settings, want 1", r.Count)
+	}
+
+	// POST /vapi/reload goes through the same reload
+	res, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d/vapi/reload", p2), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Changed  bool              `json:"changed"`
+		Settings settings.Settings `json:"settings"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || body.Changed || body.Settings.WebRoot != "two" {
+		t.Fatalf("reload: status %d, changed %v, www %q", res.StatusCode, body.Changed, body.Settings.WebRoot)
+	}
+
+	stop()
+	closed(t, p2)
+}
+
+func TestSupervisorReloadGrace(t *testing.T) {
+	const grace = 1500 * time.Millisecond
+	dir := t.TempDir()
+	for _, www := range []string{"one", "two"} {
+		writeTestFile(t, filepath.Join(dir, www, "index.html"), www)
+	}
+	config := filepath.Join(dir, "config.json")
+	p1, p2 := freePort(t), freePort(t)
+	writeTestConfig(t, config, map[string]any{"http": p1, "data": dir, "www": "one", "reload_grace": grace.String()})
+
+	start := time.Now()
+	startSupervisor(t, config)
+	if got := get(t, fmt.Sprintf("http://127.0.0.1:%d/", p1)); got != "one" {
+		t.Fatalf("before the change: got %q, want %q", got, "one")
+	}
+
+	// a change during the grace is applied once it is over
+	writeTestConfig(t, config, map[string]any{"http": p2, "data": dir, "www": "two", "reload_grace": grace.String()})
+	time.Sleep(4 * testDebounce)
+	if time.Since(start) < grace {
+		if got := get(t, fmt.Sprintf("http://127.0.0.1:%d/", p1)); got != "one" {
+			t.Fatalf("during the grace: got %q, want %q", got, "one")
+		}
+	}
+	if got := get(t, fmt.Sprintf("http://127.0.0.1:%d/", p2)); got != "two" {
+		t.Fatalf("after the grace: got %q, want %q", got, "two")
+	}
+	if d := time.Since(start); d < grace {
+		t.Fatalf("restarted after %v, within the grace of %v", d, grace)
+	}
+	closed(t, p1)
+}
@@ -2,45 +2,329 @@ package compress
 
 import (
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
+	"net/http"
+	"slices"
+	"strconv"
 	"sync"
 
+	"github.com/andybalholm/brotli"
 	"github.com/gin-gonic/gin"
 	"github.com/klauspost/compress/zstd"
 
 	"serv/zok/header"
 )
 
+const (
+	// DigestTrailer is the trailer carrying the hex SHA-256 of the uncompressed body.
+	DigestTrailer = "X-Content-SHA256"
+
+	// DebugHeader explains the compression decision when Options.Debug is set.
+	DebugHeader = "X-Compression"
+)
+
+type Options struct {
+	// Disable turns CompressResponseWriter into a no-op.
+	Disable bool
+
+	// Digest declares DigestTrailer and sends it after a compressed body.
+	Digest bool
+
+	// OnError is called once when an encoder cannot be created.
+	OnError func(error)
+
+	// Debug sets DebugHeader on every response.
+	Debug bool
+
+	// OnCloseError is called when flushing the encoder fails, typically as
+	// the client went away mid-response.
+	OnCloseError func(error)
+
+	// MinSize leaves bodies of at most this many bytes uncompressed. Without
+	// a Content-Length the body is held back until it grows past MinSize.
+	MinSize int
+
+	// Level trades compression ratio for CPU time.
+	Level Level
+}
+
+func (o Options) explain(h http.Header, decision string) {
+	if o.Debug {
+		h.Set(DebugHeader, decision)
+	}
+}
+
+type Level int
+
+const (
+	LevelDefault Level = iota
+	LevelFast
+	LevelBest
+)
+
+var ErrLevel = errors.New("unknown compression level")
+
+// ParseLevel parses fast, default or best; empty is the default.
+func ParseLevel(s string) (Level, error) {
+	switch s {
+	case "", "default":
+		return LevelDefault, nil
+	case "fast":
+		return LevelFast, nil
+	case "best":
+		return LevelBest, nil
+	}
+	return LevelDefault, fmt.Errorf("%w %q", ErrLevel, s)
+}
+
 var (
-	gzPool = sync.Pool{
-		New: func() interface{} {
-			gz, err := gzip.NewWriterLevel(io.Discard, gzip.BestSpeed)
+	gzLevels   = [...]int{LevelDefault: gzip.DefaultCompression, LevelFast: gzip.BestSpeed, LevelBest: gzip.BestCompression}
+	brLevels   = [...]int{LevelDefault: brotli.DefaultCompression, LevelFast: brotli.BestSpeed, LevelBest: brotli.BestCompression}
+	zstdLevels = [...]zstd.EncoderLevel{LevelDefault: zstd.SpeedDefault, LevelFast: zstd.SpeedFastest, LevelBest: zstd.SpeedBestCompression}
+
+	// pools of encoders, one per level
+	gzPools, brPools, zstdPools [len(gzLevels)]sync.Pool
+
+	encoderFailed sync.Once
+)
+
+func init() {
+	for i := range gzLevels {
+		gzPools[i].New = func() interface{} {
+			gz, err := gzip.NewWriterLevel(io.Discard, gzLevels[i])
 			if err != nil {
 				panic(err)
 			}
 			return gz
-		},
+		}
+		brPools[i].New = func() interface{} {
+			return brotli.NewWriterLevel(io.Discard, brLevels[i])
+		}
+		// an error is returned in place of the encoder
+		zstdPools[i].New = func() interface{} {
+			zw, err := zstd.NewWriter(io.Discard, zstd.WithEncoderLevel(zstdLevels[i]))
+			if err != nil {
+				return err
+			}
+			return zw
+		}
 	}
-)
+}
+
+func newEncoder(encoding string, level Level, w io.Writer) (io.Writer, func() error, error) {
+	if level < 0 || int(level) >= len(gzLevels) {
+		level = LevelDefault
+	}
+	switch encoding {
+	case "br":
+		pool := &brPools[level]
+		br := pool.Get().(*brotli.Writer)
+		br.Reset(w)
+		return br, func() error {
+			err := br.Close()
+			br.Reset(io.Discard)
+			pool.Put(br)
+			return err
+		}, nil
+	case "zstd":
+		pool := &zstdPools[level]
+		v := pool.Get()
+		zw, ok := v.(*zstd.Encoder)
+		if !ok {
+			return nil, nil, v.(error)
+		}
+		zw.Reset(w)
+		return zw, func() error {
+			err := zw.Close()
+			zw.Reset(io.Discard)
+			pool.Put(zw)
+			return err
+		}, nil
+	case "gzip":
+		pool := &gzPools[level]
+		gz := pool.Get().(*gzip.Writer)
+		gz.Reset(w)
+		return gz, func() error {
+			err := gz.Close()
+			gz.Reset(io.Discard)
+			pool.Put(gz)
+			return err
+		}, nil
+	}
+	return nil, nil, errors.ErrUnsupported
+}
 
 type zWriter struct {
 	gin.ResponseWriter
-	writer io.Writer
+	options Options
+	// encodings accepted by the client, in order of preference
+	encodings []string
+	// identityRefused compresses regardless of MinSize
+	identityRefused bool
+	started         bool
+	// pending holds back the body while it is not known to exceed MinSize
+	pending bool
+	buf     []byte
+	writer  io.Writer
+	close   func() error
+	hash    hash.Hash
 }
 
-func (g *zWriter) WriteString(s string) (int, error) {
+// start decides whether the response is compressed. It runs once, right
+// before the header is sent, so handlers can still opt out by setting
+// Content-Encoding themselves.
+func (g *zWriter) start() {
+	if g.started {
+		return
+	}
+	g.started = true
+	g.writer = g.ResponseWriter
+
+	// byte ranges refer to the identity representation, so partial
+	// content, including multipart/byteranges, is never compressed
+	switch status := g.ResponseWriter.Status(); {
+	case status < http.StatusOK, status == http.StatusNoContent,
+		status == http.StatusPartialContent, status == http.StatusNotModified:
+		g.options.explain(g.Header(), "skipped-status")
+		return
+	}
+
+	if g.Header().Get("Content-Encoding") != "" {
+		g.options.explain(g.Header(), "skipped-encoded")
+		return
+	}
+
+	if min := g.options.MinSize; min > 0 && !g.identityRefused {
+		if n, err := strconv.ParseInt(g.Header().Get("Content-Length"), 10, 64); err == nil {
+			if n <= int64(min) {
+				g.options.explain(g.Header(), "skipped-size")
+				return
+			}
+		} else {
+			g.pending = true
+			return
+		}
+	}
+
+	g.encode()
+}
+
+// encode switches to the first encoder that can be created.
+func (g *zWriter) encode() {
+	var encoding string
+	for _, enc := range g.encodings {
+		w, closer, err := newEncoder(enc, g.options.Level, g.ResponseWriter)
+		if err != nil {
+			encoderFailed.Do(func() {
+				if g.options.OnError != nil {
+					g.options.OnError(fmt.Errorf("%s encoder: %w", enc, err))
+				}
+			})
+			continue
+		}
+		encoding, g.writer, g.close = enc, w, closer
+		break
+	}
+	if encoding == "" {
+		g.options.explain(g.Header(), "none")
+		return
+	}
+
+	g.options.explain(g.Header(), encoding)
+	g.Header().Set("Content-Encoding", encoding)
 	g.Header().Del("Content-Length")
-	return g.writer.Write([]byte(s))
+	// offsets into a body encoded on the fly cannot be resumed
+	if g.Header().Get("Accept-Ranges") != "" {
+		g.Header().Set("Accept-Ranges", "none")
+	}
+
+	if g.options.Digest {
+		g.hash = sha256.New()
+		g.writer = io.MultiWriter(g.hash, g.writer)
+		g.Header().Add("Trailer", DigestTrailer)
+	}
+}
+
+func (g *zWriter) WriteString(s string) (int, error) {
+	return g.Write([]byte(s))
 }
 
 func (g *zWriter) Write(data []byte) (int, error) {
-	g.Header().Del("Content-Length")
+	g.start()
+	if g.pending {
+		if len(g.buf)+len(data) <= g.options.MinSize {
+			g.buf = append(g.buf, data...)
+			return len(data), nil
+		}
+		g.pending = false
+		g.encode()
+		if err := g.release(); err != nil {
+			return 0, err
+		}
+	}
 	return g.writer.Write(data)
 }
 
+// release writes the held back body.
+func (g *zWriter) release() error {
+	buf := g.buf
+	g.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := g.writer.Write(buf)
+	return err
+}
+
+// Flush sends a held back body uncompressed, a flushing handler streams
+// and waiting for MinSize would stall it.
+func (g *zWriter) Flush() {
+	if g.pending {
+		g.pending = false
+		g.options.explain(g.Header(), "skipped-size")
+		_ = g.release()
+	}
+	g.ResponseWriter.Flush()
+}
+
 func (g *zWriter) WriteHeader(code int) {
-	g.Header().Del("Content-Length")
 	g.ResponseWriter.WriteHeader(code)
+	g.start()
+}
+
+// Close flushes the encoder and returns it to its pool, also when the flush
+// fails. A body that never exceeded MinSize is written as is. Further calls
+// do nothing.
+func (g *zWriter) Close() error {
+	if g.pending {
+		g.pending = false
+		g.options.explain(g.Header(), "skipped-size")
+		if !g.Written() {
+			g.Header().Set("Content-Length", strconv.Itoa(len(g.buf)))
+		}
+		return g.release()
+	}
+	if g.close == nil {
+		return nil
+	}
+	closer := g.close
+	g.close = nil
+	err := closer()
+	if err != nil {
+		if g.options.OnCloseError != nil {
+			g.options.OnCloseError(err)
+		}
+		return err
+	}
+	if g.hash != nil {
+		g.Header().Set(DigestTrailer, hex.EncodeToString(g.hash.Sum(nil)))
+	}
+	return nil
 }
 
 type zCloser struct {
@@ -54,34 +338,42 @@ func (z *zCloser) Close() error {
 	return z.close()
 }
 
-func CompressResponseWriter(c *gin.Context) io.Closer {
-	h := header.ParseAcceptEncoding(c.Request.Header.Get("Accept-Encoding"))
-
-	switch {
-	case h.Contains("zstd"):
-		c.Header("Content-Encoding", "zstd")
-		c.Header("Vary", "Accept-Encoding")
+// CompressResponseWriter wraps c.Writer with a compressing writer chosen from
+// Accept-Encoding. It is a no-op when the response is already being compressed.
+func CompressResponseWriter(c *gin.Context, options Options) io.Closer {
+	if options.Disable {
+		options.explain(c.Writer.Header(), "disabled")
+		return &zCloser{}
+	}
+	if _, ok := c.Writer.(*zWriter); ok {
+		return &zCloser{}
+	}
+	if c.Writer.Header().Get("Content-Encoding") != "" {
+		return &zCloser{}
+	}
 
-		zw, _ := zstd.NewWriter(c.Writer)
-		c.Writer = &zWriter{c.Writer, zw}
-		return zw
-	case h.Contains("gzip"):
-		c.Header("Content-Encoding", "gzip")
-		c.Header("Vary", "Accept-Encoding")
+	h := header.ParseAcceptEncoding(c.Request.Header.Get("Accept-Encoding"))
 
-		gz := gzPool.Get().(*gzip.Writer)
+	// by the client's preference, then ours
+	encodings := h.Preferred("br", "zstd", "gzip")
+	if len(encodings) == 0 {
+		options.explain(c.Writer.Header(), "none")
+		return &zCloser{}
+	}
 
-		gz.Reset(c.Writer)
+	if !slices.Contains(c.Writer.Header().Values("Vary"), "Accept-Encoding") {
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+	}
 
-		c.Writer = &zWriter{c.Writer, gz}
+	zw := &zWriter{ResponseWriter: c.Writer, options: options, encodings: encodings, identityRefused: h.IdentityRefused()}
+	c.Writer = zw
+	return zw
+}
 
-		return &zCloser{close: func() error {
-			err := gz.Close()
-			gz.Reset(io.Discard)
-			gzPool.Put(gz)
-			return err
-		}}
+// Middleware compresses every response of the routes it is registered on.
+func Middleware(options Options) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer CompressResponseWriter(c, options).Close()
+		c.Next()
 	}
-
-	return &zCloser{}
 }
@@ -0,0 +1,161 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCompressResponseWriterWrappedTwice(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	body := strings.Repeat("serv compresses this body once. ", 256)
+
+	e := gin.New()
+	e.Use(Middleware(Options{}))
+	e.GET("/", func(c *gin.Context) {
+		defer CompressResponseWriter(c, Options{}).Close()
+		c.String(http.StatusOK, body)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr.Multistream(false)
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != body {
+		t.Fatalf("body is not the original after one gzip decode: %.40q", b)
+	}
+	if rest, _ := io.ReadAll(w.Body); len(rest) > 0 {
+		t.Fatalf("%d bytes after the gzip stream", len(rest))
+	}
+}
+
+func TestCompressResponseWriterContentEncodingSet(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	body := strings.Repeat("z", 4096)
+
+	e := gin.New()
+	e.GET("/", func(c *gin.Context) {
+		c.Header("Content-Encoding", "br")
+		defer CompressResponseWriter(c, Options{}).Close()
+		c.String(http.StatusOK, body)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Content-Encoding"); got != "br" {
+		t.Fatalf("Content-Encoding = %q, want br", got)
+	}
+	if w.Body.String() != body {
+		t.Fatal("body was re-encoded")
+	}
+}
+
+func TestCompressResponseWriterMultipartRanges(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	content := []byte(strings.Repeat("0123456789", 40))
+
+	e := gin.New()
+	e.Use(Middleware(Options{}))
+	e.GET("/file.txt", func(c *gin.Context) {
+		http.ServeContent(c.Writer, c.Request, "file.txt", time.Time{}, bytes.NewReader(content))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=0-99,200-299")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", w.Code)
+	}
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("Content-Encoding = %q, want none", got)
+	}
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/byteranges" || params["boundary"] == "" {
+		t.Fatalf("Content-Type = %q, want multipart/byteranges; boundary=...", w.Header().Get("Content-Type"))
+	}
+
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	for _, r := range [][2]int{{0, 99}, {200, 299}} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := fmt.Sprintf("bytes %d-%d/%d", r[0], r[1], len(content))
+		if got := part.Header.Get("Content-Range"); got != want {
+			t.Errorf("Content-Range = %q, want %q", got, want)
+		}
+		b, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, content[r[0]:r[1]+1]) {
+			t.Errorf("part %s = %q", want, b)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Fatalf("after the last part: %v, want EOF", err)
+	}
+}
+
+func TestCompressResponseWriterAcceptEncoding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		acceptEncoding string
+		body           string
+		want           string
+	}{
+		{"gzip;q=1.0, zstd;q=0.1", strings.Repeat("a", 4096), "gzip"},
+		{"zstd, br;q=0.9, gzip;q=0.5", strings.Repeat("a", 4096), "zstd"},
+		{"br;q=0, gzip", strings.Repeat("a", 4096), "gzip"},
+		{"br;q=0, zstd;q=0, gzip;q=0", strings.Repeat("a", 4096), ""},
+		// too small to compress, unless identity is refused
+		{"gzip", "small", ""},
+		{"gzip, identity;q=0", "small", "gzip"},
+	}
+	for _, tt := range tests {
+		e := gin.New()
+		e.GET("/", func(c *gin.Context) {
+			defer CompressResponseWriter(c, Options{MinSize: 1024}).Close()
+			c.String(http.StatusOK, tt.body)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		if got := w.Header().Get("Content-Encoding"); got != tt.want {
+			t.Errorf("%q: Content-Encoding = %q, want %q", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package header
 
 import (
+	"cmp"
 	"math"
 	"slices"
 	"strconv"
@@ -10,40 +11,131 @@ import (
 type AcceptSpec struct {
 	Value string
 	Q     float64
+	// Params are the media type parameters other than q, such as level=1.
+	Params map[string]string
 }
 
 type Accepts []AcceptSpec
 
-// Parse HTTP header: 'Accept-Language'
+func parseQ(v string) (float64, bool) {
+	q, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(q) || math.IsInf(q, 0) || q < 0.0 {
+		return 0, false
+	}
+	if q > 1.000 {
+		q = 1.000
+	}
+	return q, true
+}
+
+// Parse HTTP header: 'Accept', 'Accept-Language'
 func ParseAccept(header string) Accepts {
 	if header == "" {
 		return nil
 	}
 	var items []AcceptSpec
 	for _, v := range strings.Split(header, ",") {
-		v = strings.TrimSpace(v)
-		if len(v) > 0 && !strings.Contains(v, ";") {
-			items = append(items, AcceptSpec{Value: v, Q: 1.000})
-		} else if s := strings.Split(v, ";"); len(s) == 2 {
-			spec := AcceptSpec{}
-			spec.Value = strings.TrimSpace(s[0])
-			spec.Q = 1.000 // default
-			s[1] = strings.TrimSpace(s[1])
-			if strings.HasPrefix(s[1], "q=") {
-				q, err := strconv.ParseFloat(s[1][2:], 64)
-				if err == nil && !math.IsNaN(q) && !math.IsInf(q, 0) && q >= 0.0 {
-					if q > 1.000 {
-						q = 1.000
-					}
+		s := strings.Split(v, ";")
+		spec := AcceptSpec{Value: strings.TrimSpace(s[0]), Q: 1.000}
+		if spec.Value == "" {
+			continue
+		}
+		for _, p := range s[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			k, v = strings.ToLower(strings.TrimSpace(k)), strings.Trim(strings.TrimSpace(v), `"`)
+			if k == "" {
+				continue
+			}
+			// parameters after q are accept-ext, not media type parameters
+			if k == "q" {
+				if q, ok := parseQ(v); ok {
 					spec.Q = q
 				}
+				break
 			}
-			items = append(items, spec)
+			if spec.Params == nil {
+				spec.Params = map[string]string{}
+			}
+			spec.Params[k] = v
 		}
+		items = append(items, spec)
 	}
 	return items
 }
 
+// specificity of a media range: 0 for */*, 1 for type/*, 2 for type/subtype
+// and 3 with parameters. It is -1 when the range does not match the offer.
+func (spec AcceptSpec) specificity(offer AcceptSpec) int {
+	t, sub, _ := strings.Cut(strings.ToLower(offer.Value), "/")
+	rt, rsub, _ := strings.Cut(strings.ToLower(spec.Value), "/")
+	switch {
+	case rt == "*" && rsub == "*":
+		return 0
+	case rt != t:
+		return -1
+	case rsub == "*":
+		return 1
+	case rsub != sub:
+		return -1
+	case len(spec.Params) == 0:
+		return 2
+	}
+	for k, v := range spec.Params {
+		if offer.Params[k] != v {
+			return -1
+		}
+	}
+	return 3
+}
+
+func (a Accepts) match(mediaType string) (q float64, specificity int) {
+	offer := ParseAccept(mediaType)
+	if len(offer) == 0 {
+		return 0, -1
+	}
+	specificity = -1
+	for _, spec := range a {
+		if n := spec.specificity(offer[0]); n > specificity {
+			q, specificity = spec.Q, n
+		}
+	}
+	return
+}
+
+// Quality returns the q-value of mediaType taken from the most specific
+// matching media range, so "text/*;q=0.5, text/html" gives text/html 1 and
+// text/plain 0.5. It is 0 when nothing matches. mediaType may carry
+// parameters, such as "text/html;level=1".
+func (a Accepts) Quality(mediaType string) float64 {
+	q, _ := a.match(mediaType)
+	return q
+}
+
+// Best returns the offer the client prefers, or "" if none is acceptable.
+// Ties go to the offer matched by the more specific range, then to the
+// earlier offer. Without an Accept header the first offer is returned.
+func (a Accepts) Best(offers ...string) string {
+	if len(a) == 0 {
+		if len(offers) == 0 {
+			return ""
+		}
+		return offers[0]
+	}
+	best, bestQ, bestN := "", 0.0, -1
+	for _, offer := range offers {
+		q, n := a.match(offer)
+		if q < 0.001 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && n > bestN) {
+			best, bestQ, bestN = offer, q, n
+		}
+	}
+	return best
+}
+
+// Contains reports whether value is listed literally, without matching
+// wildcards; see Quality.
 func (a Accepts) Contains(value string) bool {
 	for _, spec := range a {
 		// NOTE: 0 means not "not acceptable"
@@ -64,16 +156,64 @@ func (a Accepts) ContainPrefix(value string) bool {
 	return false
 }
 
-type AcceptEncoding []string
+// AcceptEncoding is a parsed Accept-Encoding header, most preferred
+// coding first.
+type AcceptEncoding []AcceptSpec
 
 func ParseAcceptEncoding(header string) AcceptEncoding {
-	s := strings.Split(header, ",")
-	for i := range s {
-		s[i] = strings.TrimSpace(s[i])
+	a := AcceptEncoding(ParseAccept(header))
+	for i := range a {
+		a[i].Value = strings.ToLower(a[i].Value)
 	}
-	return s
+	slices.SortStableFunc(a, func(x, y AcceptSpec) int {
+		return cmp.Compare(y.Q, x.Q)
+	})
+	return a
+}
+
+// Quality of a content coding. A coding the header does not list has the
+// quality of *, if listed; identity is acceptable unless excluded that way.
+func (a AcceptEncoding) Quality(coding string) float64 {
+	coding = strings.ToLower(coding)
+	wildcard := -1.0
+	for _, spec := range a {
+		switch spec.Value {
+		case coding:
+			return spec.Q
+		case "*":
+			wildcard = spec.Q
+		}
+	}
+	if wildcard >= 0 {
+		return wildcard
+	}
+	if coding == "identity" {
+		return 1
+	}
+	return 0
 }
 
 func (a AcceptEncoding) Contains(value string) bool {
-	return slices.Contains(a, value)
+	return a.Quality(value) > 0
+}
+
+// Preferred returns the acceptable offers, the most preferred by the client
+// first; offers of equal quality keep their order.
+func (a AcceptEncoding) Preferred(offers ...string) []string {
+	var s []string
+	for _, v := range offers {
+		if a.Contains(v) {
+			s = append(s, v)
+		}
+	}
+	slices.SortStableFunc(s, func(x, y string) int {
+		return cmp.Compare(a.Quality(y), a.Quality(x))
+	})
+	return s
+}
+
+// IdentityRefused reports whether the client excludes an uncompressed
+// response with identity;q=0, or *;q=0 without listing identity.
+func (a AcceptEncoding) IdentityRefused() bool {
+	return !a.Contains("identity")
 }
@@ -0,0 +1,125 @@
+package header
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAcceptsQuality(t *testing.T) {
+	tests := []struct {
+		header string
+		offers map[string]float64
+	}{
+		// RFC 7231 section 5.3.2
+		{
+			"text/*;q=0.3, text/html;q=0.7, text/html;level=1, text/html;level=2;q=0.4, */*;q=0.5",
+			map[string]float64{
+				"text/html;level=1": 1,
+				"text/html":         0.7,
+				"text/plain":        0.3,
+				"image/jpeg":        0.5,
+				"text/html;level=2": 0.4,
+				"text/html;level=3": 0.7,
+			},
+		},
+		{
+			"audio/*; q=0.2, audio/basic",
+			map[string]float64{
+				"audio/basic": 1,
+				"audio/mpeg":  0.2,
+				"video/mp4":   0,
+			},
+		},
+		{
+			"text/html;q=0, */*",
+			map[string]float64{
+				"text/html":        0,
+				"application/json": 1,
+			},
+		},
+		{
+			"TEXT/HTML;Q=0.5",
+			map[string]float64{
+				"text/html": 0.5,
+			},
+		},
+		{
+			"",
+			map[string]float64{
+				"text/html": 0,
+			},
+		},
+	}
+	for _, tt := range tests {
+		a := ParseAccept(tt.header)
+		for offer, want := range tt.offers {
+			if got := a.Quality(offer); got != want {
+				t.Errorf("%q: Quality(%q) = %v, want %v", tt.header, offer, got, want)
+			}
+		}
+	}
+}
+
+func TestAcceptsBest(t *testing.T) {
+	tests := []struct {
+		header string
+		offers []string
+		want   string
+	}{
+		// RFC 7231 section 5.3.2: text/html and text/x-c are equally preferred
+		{"text/plain; q=0.5, text/html, text/x-dvi; q=0.8, text/x-c", []string{"text/plain", "text/x-dvi", "text/html"}, "text/html"},
+		{"text/plain; q=0.5, text/html, text/x-dvi; q=0.8, text/x-c", []string{"text/x-c", "text/html"}, "text/x-c"},
+		{"text/plain; q=0.5, text/html, text/x-dvi; q=0.8, text/x-c", []string{"text/plain", "text/x-dvi"}, "text/x-dvi"},
+		// a more specific range wins a tie
+		{"text/*, application/json", []string{"text/html", "application/json"}, "application/json"},
+		{"text/*;q=0.8, application/json", []string{"text/html", "application/json"}, "application/json"},
+		{"text/html;q=0, */*;q=0.1", []string{"text/html"}, ""},
+		{"", []string{"text/html", "application/json"}, "text/html"},
+		{"image/*", nil, ""},
+	}
+	for _, tt := range tests {
+		if got := ParseAccept(tt.header).Best(tt.offers...); got != tt.want {
+			t.Errorf("%q: Best(%q) = %q, want %q", tt.header, tt.offers, got, tt.want)
+		}
+	}
+}
+
+func TestAcceptEncoding(t *testing.T) {
+	offers := []string{"br", "zstd", "gzip"}
+	tests := []struct {
+		header          string
+		preferred       []string
+		identityRefused bool
+	}{
+		{"", nil, false},
+		{"gzip, deflate, br, zstd", []string{"br", "zstd", "gzip"}, false},
+		{"gzip;q=1.0, zstd;q=0.1", []string{"gzip", "zstd"}, false},
+		{"br;q=0.5, gzip;q=0.8, zstd;q=0.8", []string{"zstd", "gzip", "br"}, false},
+		{"GZIP;Q=0.3, Br", []string{"br", "gzip"}, false},
+		{"gzip, br;q=0", []string{"gzip"}, false},
+		{"*", []string{"br", "zstd", "gzip"}, false},
+		{"*;q=0.5, br", []string{"br", "zstd", "gzip"}, false},
+		{"*;q=0, gzip", []string{"gzip"}, true},
+		{"*;q=0, identity, gzip;q=0.5", []string{"gzip"}, false},
+		{"gzip, identity;q=0", []string{"gzip"}, true},
+		{"identity;q=0", nil, true},
+		{"br;q=0, zstd;q=0, gzip;q=0", nil, false},
+	}
+	for _, tt := range tests {
+		a := ParseAcceptEncoding(tt.header)
+		if got := a.Preferred(offers...); !slices.Equal(got, tt.preferred) {
+			t.Errorf("%q: Preferred = %q, want %q", tt.header, got, tt.preferred)
+		}
+		if got := a.IdentityRefused(); got != tt.identityRefused {
+			t.Errorf("%q: IdentityRefused = %v, want %v", tt.header, got, tt.identityRefused)
+		}
+	}
+
+	a := ParseAcceptEncoding("zstd;q=0.1, br;q=0, gzip;q=1.0")
+	if a[0].Value != "gzip" || a[len(a)-1].Value != "br" {
+		t.Errorf("not sorted by q-value: %v", a)
+	}
+	if q := a.Quality("br"); q != 0 {
+		t.Errorf("Quality(br) = %v, want 0", q)
+	}
+}
@@ -1,9 +1,11 @@
 package log
 
 import (
+	"encoding/json"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -15,18 +17,90 @@ import (
 const DefaultLogName = "logs/messages.log"
 
 var (
+	std = New(zap.NewNop())
+)
+
+// Logger is a leveled logger. The package level functions write to the
+// Logger created by Open.
+type Logger struct {
+	// mu is held for reading by every write, so Reconfigure can swap the
+	// core once the writes in flight have finished.
+	mu   sync.RWMutex
+	core *core
+}
+
+type core struct {
+	mode     Mode
 	filename string
-	opts     Options
 	logger   *zap.Logger
 	sugar    *zap.SugaredLogger
 	w        *LogrotateWriter
-)
+	// stop ends the periodic sync, if any
+	stop func()
+}
+
+// New returns a Logger writing to l. It has no log file, so Filename is
+// empty and Rotate does nothing.
+func New(l *zap.Logger) *Logger {
+	return &Logger{core: &core{logger: l, sugar: l.Sugar()}}
+}
+
+func (l *Logger) acquire() (*core, func()) {
+	l.mu.RLock()
+	return l.core, l.mu.RUnlock
+}
+
+// Default returns the Logger used by the package level functions.
+func Default() *Logger {
+	return std
+}
 
 type LogEntry struct {
 	Level   zapcore.Level `json:"level"`
 	Time    time.Time     `json:"ts"`
 	Message string        `json:"msg"`
 	Stack   string        `json:"stack,omitempty"`
+
+	// Fields holds the structured key/value pairs of the record, such as
+	// those passed to Infow.
+	Fields map[string]any `json:"-"`
+}
+
+type logEntry LogEntry
+
+var logEntryKeys = []string{"level", "ts", "msg", "stack"}
+
+func (e *LogEntry) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*logEntry)(e)); err != nil {
+		return err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	for _, k := range logEntryKeys {
+		delete(m, k)
+	}
+	e.Fields = nil
+	if len(m) > 0 {
+		e.Fields = m
+	}
+	return nil
+}
+
+func (e LogEntry) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(logEntry(e))
+	if err != nil || len(e.Fields) == 0 {
+		return data, err
+	}
+	m := make(map[string]any, len(e.Fields)+len(logEntryKeys))
+	for k, v := range e.Fields {
+		m[k] = v
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
 }
 
 type multiWriteCloser struct {
@@ -53,25 +127,56 @@ const (
 type Options struct {
 	Mode     Mode
 	Filename string
+	// Rotation of the log file in File mode.
+	Rotation Rotation
+	// SyncInterval syncs the log file this often in File mode, so that a
+	// crash loses at most that much of the log; 0 syncs on Close only.
+	SyncInterval time.Duration
+}
+
+// Rotation is when log files are rotated and how many are kept. Zero values
+// rotate at 4 MiB and keep 6 backups of any age, uncompressed.
+type Rotation struct {
+	MaxSize    int
+	MaxBackups int
+	MaxAge     time.Duration
+	Compress   bool
 }
 
 func Open(options Options) {
-	opts = options
-	filename = opts.Filename
+	std = &Logger{core: open(options)}
+}
 
-	var err error
+// Reconfigure replaces the output of the Logger created by Open.
+func Reconfigure(options Options) error {
+	return std.Reconfigure(options)
+}
+
+// Reconfigure switches l to a new output. Writes already in progress finish
+// on the old output, which is closed after them.
+func (l *Logger) Reconfigure(options Options) error {
+	c := open(options)
+	l.mu.Lock()
+	old := l.core
+	l.core = c
+	l.mu.Unlock()
+	return old.close()
+}
 
-	if opts.Mode == "" {
-		opts.Mode = Stdout
+func open(opts Options) *core {
+	l := &core{mode: opts.Mode, filename: opts.Filename}
+
+	if l.mode == "" {
+		l.mode = Stdout
 	}
 
-	if opts.Mode == File {
-		if filename == "" {
-			filename = "app.log"
+	if l.mode == File {
+		if l.filename == "" {
+			l.filename = "app.log"
 		}
 	}
 
-	if opts.Mode == Stdout {
+	if l.mode == Stdout {
 		c := zap.NewProductionConfig()
 		c.Level = zap.NewAtomicLevelAt(settings.LogLevel)
 		c.OutputPaths = []string{"stdout"}
@@ -79,168 +184,374 @@ func Open(options Options) {
 		c.EncoderConfig.EncodeTime = zapcore.RFC3339TimeEncoder
 		c.EncoderConfig.CallerKey = zapcore.OmitKey
 		c.EncoderConfig.StacktraceKey = zapcore.OmitKey
-		logger, err = c.Build()
+		v, err := c.Build()
 		if err != nil {
 			panic(err)
 		}
-		sugar = logger.Sugar()
-		return
+		l.logger = v
+		l.sugar = v.Sugar()
+		return l
 	}
 
-	w = NewLogrotateWriter(LogrotateOption{
-		Filename:   filepath.Join(filepath.Clean(filename)),
-		MaxSize:    4 << 20,
-		MaxBackups: 6,
-		Compress:   true,
-	})
+	l.w = NewFile(l.filename, opts.Rotation)
 
 	encoderConfig := zap.NewProductionEncoderConfig()
 	encoderConfig.EncodeTime = zapcore.RFC3339TimeEncoder
 	encoderConfig.CallerKey = zapcore.OmitKey
 	encoderConfig.StacktraceKey = zapcore.OmitKey
-	enc, ws := zapcore.NewJSONEncoder(encoderConfig), zapcore.AddSync(w)
+	enc, ws := zapcore.NewJSONEncoder(encoderConfig), zapcore.AddSync(l.w)
 
-	v := zap.New(zapcore.NewCore(enc, ws, settings.LogLevel))
-	logger = v
-	sugar = v.Sugar()
+	zl := zap.New(zapcore.NewCore(enc, ws, settings.LogLevel))
+	l.logger = zl
+	l.sugar = zl.Sugar()
+	if d := opts.SyncInterval; d > 0 {
+		l.stop = l.syncEvery(d)
+	}
+	return l
 }
 
-func Close() (err error) {
-	if opts.Mode != Stdout {
-		err = logger.Sync()
+// NewFile returns a writer to filename rotated by r.
+func NewFile(filename string, r Rotation) *LogrotateWriter {
+	if r.MaxSize <= 0 {
+		r.MaxSize = 4 << 20
 	}
-	if w != nil {
-		if err2 := w.Close(); err2 != nil && err == nil {
-			err = err2
+	if r.MaxBackups <= 0 {
+		r.MaxBackups = 6
+	}
+	return NewLogrotateWriter(LogrotateOption{
+		Filename:   filepath.Clean(filename),
+		MaxSize:    r.MaxSize,
+		MaxBackups: r.MaxBackups,
+		MaxAge:     r.MaxAge,
+		Compress:   r.Compress,
+	})
+}
+
+// syncEvery syncs the log file every d, so that a crash loses at most d of
+// logs. The returned function stops it and waits for a sync in progress.
+func (l *core) syncEvery(d time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(d)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				_ = l.logger.Sync()
+			}
 		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
 	}
-	return
+}
+
+func Close() error {
+	return std.Close()
 }
 
 func Filename() string {
-	return filename
+	return std.Filename()
 }
 
 func Rotate() error {
-	if w == nil {
+	return std.Rotate()
+}
+
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.core.close()
+}
+
+func (l *core) close() (err error) {
+	if l.stop != nil {
+		l.stop()
+	}
+	if l.mode != Stdout {
+		err = l.logger.Sync()
+	}
+	if l.w != nil {
+		if err2 := l.w.Close(); err2 != nil && err == nil {
+			err = err2
+		}
+	}
+	return
+}
+
+func (l *Logger) Filename() string {
+	c, done := l.acquire()
+	defer done()
+	return c.filename
+}
+
+func (l *Logger) Rotate() error {
+	c, done := l.acquire()
+	defer done()
+	if c.w == nil {
 		return nil
 	}
-	return w.Rotate()
+	return c.w.Rotate()
+}
+
+func (l *Logger) DebugFields(msg string, fields ...zap.Field) {
+	c, done := l.acquire()
+	defer done()
+	c.logger.Debug(msg, fields...)
+}
+
+func (l *Logger) InfoFields(msg string, fields ...zap.Field) {
+	c, done := l.acquire()
+	defer done()
+	c.logger.Info(msg, fields...)
+}
+
+func (l *Logger) WarnFields(msg string, fields ...zap.Field) {
+	c, done := l.acquire()
+	defer done()
+	c.logger.Warn(msg, fields...)
+}
+
+func (l *Logger) ErrorFields(msg string, fields ...zap.Field) {
+	c, done := l.acquire()
+	defer done()
+	c.logger.Error(msg, fields...)
+}
+
+func (l *Logger) PanicFields(msg string, fields ...zap.Field) {
+	c, done := l.acquire()
+	defer done()
+	c.logger.Panic(msg, fields...)
+}
+
+func (l *Logger) FatalFields(msg string, fields ...zap.Field) {
+	c, done := l.acquire()
+	defer done()
+	c.logger.Fatal(msg, fields...)
+}
+
+func (l *Logger) Debugw(msg string, args ...any) {
+	c, done := l.acquire()
+	defer done()
+	c.sugar.Debugw(msg, args...)
+}
+
+func (l *Logger) Infow(msg string, args ...any) {
+	c, done := l.acquire()
+	defer done()
+	c.sugar.Infow(msg, args...)
+}
+
+func (l *Logger) Warnw(msg string, args ...any) {
+	c, done := l.acquire()
+	defer done()
+	c.sugar.Warnw(msg, args...)
+}
+
+func (l *Logger) Errorw(msg string, args ...any) {
+	c, done := l.acquire()
+	defer done()
+	c.sugar.Errorw(msg, args...)
+}
+
+func (l *Logger) Panicw(msg string, args ...any) {
+	c, done := l.acquire()
+	defer done()
+	c.sugar.Panicw(msg, args...)
+}
+
+func (l *Logger) Fatalw(msg string, args ...any) {
+	c, done := l.acquire()
+	defer done()
+	c.sugar.Fatalw(msg, args...)
+}
+
+func (l *Logger) Debug(args ...any) {
+	c, done := l.acquire()
+	defer done()
+	c.sugar.Debugln(args...)
+}
+
+func (l *Logger) Info(args ...any) {
+	c, done := l.acquire()
+	defer done()
+	c.sugar.Infoln(args...)
+}
+
+func (l *Logger) Warn(args ...any) {
+	c, done := l.acquire()
+	defer done()
+	c.sugar.Warnln(args...)
+}
+
+func (l *Logger) Debugf(format string, args ...any) {
+	c, done := l.acquire()
+	defer done()
+	c.sugar.Debugf(format, args...)
+}
+
+func (l *Logger) Infof(format string, args ...any) {
+	c, done := l.acquire()
+	defer done()
+	c.sugar.Infof(format, args...)
+}
+
+func (l *Logger) Warnf(format string, args ...any) {
+	c, done := l.acquire()
+	defer done()
+	c.sugar.Warnf(format, args...)
+}
+
+func t(s string, err error) (msg string, fields []zap.Field) {
+	if s != "" {
+		msg = s + ": "
+	}
+
+	if err != nil {
+		msg += err.Error()
+	}
+
+	if err, ok := AsTracedError(err); ok {
+		fields = append(fields, zap.String("stack", err.Stack()))
+	}
+
+	return
+}
+
+func (l *Logger) ErrorP(prefix string, e error) {
+	msg, fields := t(prefix, e)
+	l.ErrorFields(msg, fields...)
+}
+
+func (l *Logger) PanicP(prefix string, e error) {
+	msg, fields := t(prefix, e)
+	l.PanicFields(msg, fields...)
+}
+
+func (l *Logger) FatalP(prefix string, e error) {
+	msg, fields := t(prefix, e)
+	l.FatalFields(msg, fields...)
+}
+
+func (l *Logger) Error(e error) {
+	msg, fields := t("", e)
+	l.ErrorFields(msg, fields...)
+}
+
+// ErrorWith is Error with the fields added, such as the request the error
+// belongs to.
+func (l *Logger) ErrorWith(e error, fields ...zap.Field) {
+	msg, f := t("", e)
+	l.ErrorFields(msg, append(f, fields...)...)
+}
+
+func (l *Logger) Panic(e error) {
+	msg, fields := t("", e)
+	l.PanicFields(msg, fields...)
+}
+
+func (l *Logger) Fatal(e error) {
+	msg, fields := t("", e)
+	l.FatalFields(msg, fields...)
 }
 
 func DebugFields(msg string, fields ...zap.Field) {
-	logger.Debug(msg, fields...)
+	std.DebugFields(msg, fields...)
 }
 
 func InfoFields(msg string, fields ...zap.Field) {
-	logger.Info(msg, fields...)
+	std.InfoFields(msg, fields...)
 }
 
 func WarnFields(msg string, fields ...zap.Field) {
-	logger.Warn(msg, fields...)
+	std.WarnFields(msg, fields...)
 }
 
 func ErrorFields(msg string, fields ...zap.Field) {
-	logger.Error(msg, fields...)
+	std.ErrorFields(msg, fields...)
 }
 
 func PanicFields(msg string, fields ...zap.Field) {
-	logger.Panic(msg, fields...)
+	std.PanicFields(msg, fields...)
 }
 
 func FatalFields(msg string, fields ...zap.Field) {
-	logger.Fatal(msg, fields...)
+	std.FatalFields(msg, fields...)
 }
 
 func Debugw(msg string, args ...any) {
-	sugar.Debugw(msg, args...)
+	std.Debugw(msg, args...)
 }
 
 func Infow(msg string, args ...any) {
-	sugar.Infow(msg, args...)
+	std.Infow(msg, args...)
 }
 
 func Warnw(msg string, args ...any) {
-	sugar.Warnw(msg, args...)
+	std.Warnw(msg, args...)
 }
 
 func Errorw(msg string, args ...any) {
-	sugar.Errorw(msg, args...)
+	std.Errorw(msg, args...)
 }
 
 func Panicw(msg string, args ...any) {
-	sugar.Panicw(msg, args...)
+	std.Panicw(msg, args...)
 }
 
 func Fatalw(msg string, args ...any) {
-	sugar.Fatalw(msg, args...)
+	std.Fatalw(msg, args...)
 }
 
 func Debug(args ...any) {
-	sugar.Debugln(args...)
+	std.Debug(args...)
 }
 
 func Info(args ...any) {
-	sugar.Infoln(args...)
+	std.Info(args...)
 }
 
 func Warn(args ...any) {
-	sugar.Warnln(args...)
+	std.Warn(args...)
 }
 
 func Debugf(format string, args ...any) {
-	sugar.Debugf(format, args...)
+	std.Debugf(format, args...)
 }
+
 func Infof(format string, args ...any) {
-	sugar.Infof(format, args...)
+	std.Infof(format, args...)
 }
 
 func Warnf(format string, args ...any) {
-	sugar.Warnf(format, args...)
-}
-
-func t(s string, err error) (msg string, fields []zap.Field) {
-	if s != "" {
-		msg = s + ": "
-	}
-
-	if err != nil {
-		msg += err.Error()
-	}
-
-	if err, ok := AsTracedError(err); ok {
-		fields = append(fields, zap.String("stack", err.Stack()))
-	}
-
-	return
+	std.Warnf(format, args...)
 }
 
 func ErrorP(prefix string, e error) {
-	msg, fields := t(prefix, e)
-	ErrorFields(msg, fields...)
+	std.ErrorP(prefix, e)
 }
 
 func PanicP(prefix string, e error) {
-	msg, fields := t(prefix, e)
-	PanicFields(msg, fields...)
+	std.PanicP(prefix, e)
 }
 
 func FatalP(prefix string, e error) {
-	msg, fields := t(prefix, e)
-	FatalFields(msg, fields...)
+	std.FatalP(prefix, e)
 }
 
 func Error(e error) {
-	msg, fields := t("", e)
-	ErrorFields(msg, fields...)
+	std.Error(e)
 }
 
 func Panic(e error) {
-	msg, fields := t("", e)
-	PanicFields(msg, fields...)
+	std.Panic(e)
 }
 
 func Fatal(e error) {
-	msg, fields := t("", e)
-	FatalFields(msg, fields...)
+	std.Fatal(e)
 }
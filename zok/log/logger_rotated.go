@@ -98,6 +98,16 @@ func (l *LogrotateWriter) Close() error {
 	return l.close()
 }
 
+// Sync commits the current log file to stable storage.
+func (l *LogrotateWriter) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Sync()
+}
+
 func (l *LogrotateWriter) Rotate() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
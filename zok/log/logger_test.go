@@ -0,0 +1,86 @@
+package log
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestReconfigureDuringWrites(t *testing.T) {
+	const writers, reconfigures = 8, 10
+	dir := t.TempDir()
+	filename := func(i int) string {
+		return filepath.Join(dir, fmt.Sprintf("app-%d.log", i))
+	}
+
+	l := &Logger{core: open(Options{Mode: File, Filename: filename(0)})}
+	var wg sync.WaitGroup
+	var n atomic.Int64
+	var stop atomic.Bool
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; !stop.Load(); i++ {
+				l.InfoFields("write", zap.Int("writer", w), zap.Int("i", i))
+				n.Add(1)
+				time.Sleep(100 * time.Microsecond)
+			}
+		}()
+	}
+	// every file gets some of the writes
+	wait := func() {
+		for m := n.Load() + 100; n.Load() < m; {
+			runtime.Gosched()
+		}
+	}
+	for i := 1; i <= reconfigures; i++ {
+		wait()
+		if err := l.Reconfigure(Options{Mode: File, Filename: filename(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wait()
+	stop.Store(true)
+	wg.Wait()
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// every write lands complete in exactly one of the files
+	seen := map[[2]int]bool{}
+	for i := 0; i <= reconfigures; i++ {
+		f, err := os.Open(filename(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			var e struct{ Writer, I int }
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				t.Fatalf("%s: %v: %q", filename(i), err, sc.Text())
+			}
+			k := [2]int{e.Writer, e.I}
+			if seen[k] {
+				t.Fatalf("write %v logged twice", k)
+			}
+			seen[k] = true
+		}
+		f.Close()
+		if err := sc.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(seen) != int(n.Load()) {
+		t.Fatalf("%d writes logged, want %d", len(seen), n.Load())
+	}
+}
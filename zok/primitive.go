@@ -3,6 +3,7 @@ package zok
 import (
 	"encoding/json"
 	"strconv"
+	"time"
 )
 
 type Bool bool
@@ -115,3 +116,49 @@ func NewStringSlice(values []string) []String {
 	}
 	return s
 }
+
+// Duration is a time.Duration decoded from strings such as "1m30s". Bare
+// numbers are taken as seconds.
+type Duration time.Duration
+
+func (d Duration) Value() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return d.Value().String()
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		sec, err2 := strconv.ParseFloat(string(text), 64)
+		if err2 != nil {
+			return err
+		}
+		v = time.Duration(sec * float64(time.Second))
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	value := string(data)
+	if v, err := strconv.Unquote(value); err == nil {
+		return d.UnmarshalText([]byte(v))
+	}
+	sec, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	*d = Duration(sec * float64(time.Second))
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
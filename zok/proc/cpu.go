@@ -5,22 +5,24 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"strings"
+	"time"
 )
 
 type CPUStat struct {
-	User    float64
-	Nice    float64
-	System  float64
-	Idle    float64
-	Iowait  float64
-	IRQ     float64
-	SoftIRQ float64
-
-	Steal     float64
-	Guest     float64
-	GuestNice float64
+	User    float64 `json:"user"`
+	Nice    float64 `json:"nice"`
+	System  float64 `json:"system"`
+	Idle    float64 `json:"idle"`
+	Iowait  float64 `json:"iowait"`
+	IRQ     float64 `json:"irq"`
+	SoftIRQ float64 `json:"softirq"`
+
+	Steal     float64 `json:"steal"`
+	Guest     float64 `json:"guest"`
+	GuestNice float64 `json:"guest_nice"`
 }
 
 var ErrParseProcStat = errors.New("parse /proc/stat error")
@@ -83,3 +85,47 @@ func Stat() (map[string]CPUStat, error) {
 	}
 	return ret, nil
 }
+
+var ErrProcessExited = errors.New("process exited")
+
+// CPUPercent samples the CPU time of the process pid and of all CPUs twice,
+// interval apart, and returns the share of the process in percent of the
+// whole machine. It fails with ErrProcessExited when there is no process
+// pid at either sample.
+func CPUPercent(pid int, interval time.Duration) (float64, error) {
+	p0, t0, err := cpuSample(pid)
+	if err != nil {
+		return 0, err
+	}
+	time.Sleep(interval)
+	p1, t1, err := cpuSample(pid)
+	if err != nil {
+		return 0, err
+	}
+	total := t1 - t0
+	if total <= 0 {
+		return 0, nil
+	}
+	return min(max((p1-p0)/total*100, 0), 100), nil
+}
+
+// cpuSample returns the user and system time of the process pid and the
+// total time of all CPUs, both in clock ticks.
+func cpuSample(pid int) (process, total float64, err error) {
+	p, err := PStat(pid)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, 0, fmt.Errorf("%w: %w", ErrProcessExited, err)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	stat, err := Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	cpu, ok := stat["cpu"]
+	if !ok {
+		return 0, 0, ErrParseProcStat
+	}
+	return p.UserTime + p.SysTime, cpu.TotalTime(), nil
+}
@@ -0,0 +1,26 @@
+package proc
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCPUPercent(t *testing.T) {
+	if _, err := os.Stat("/proc/stat"); err != nil {
+		t.Skip("no /proc:", err)
+	}
+	p, err := CPUPercent(os.Getpid(), 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p < 0 || p > 100 {
+		t.Errorf("CPUPercent = %v, want 0 to 100", p)
+	}
+
+	// above the largest pid_max, so there is no such process
+	if _, err := CPUPercent(1<<22+1, time.Millisecond); !errors.Is(err, ErrProcessExited) {
+		t.Errorf("missing process: %v, want ErrProcessExited", err)
+	}
+}
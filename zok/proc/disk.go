@@ -0,0 +1,20 @@
+package proc
+
+import (
+	"os"
+	"syscall"
+)
+
+// DiskUsage returns the size of the file system holding path, the bytes
+// free to unprivileged users and the bytes in use.
+func DiskUsage(path string) (total, free, used uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, 0, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	bsize := uint64(st.Bsize)
+	total = st.Blocks * bsize
+	free = st.Bavail * bsize
+	used = (st.Blocks - st.Bfree) * bsize
+	return total, free, used, nil
+}
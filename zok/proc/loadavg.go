@@ -0,0 +1,62 @@
+package proc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ErrParseLoadAvg = errors.New("parse /proc/loadavg error")
+
+type LoadAvgData struct {
+	Load1   float64 `json:"load1"`
+	Load5   float64 `json:"load5"`
+	Load15  float64 `json:"load15"`
+	Running int     `json:"running"` // runnable scheduling entities
+	Total   int     `json:"total"`   // existing scheduling entities
+}
+
+func LoadAvg() (*LoadAvgData, error) {
+	f, err := os.Open("/proc/loadavg")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseLoadAvg(f)
+}
+
+// parseLoadAvg reads a line like "0.52 0.58 0.59 1/467 12345".
+func parseLoadAvg(r io.Reader) (*LoadAvgData, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return nil, ErrParseLoadAvg
+	}
+	tokens := strings.Fields(scanner.Text())
+	if len(tokens) < 4 {
+		return nil, ErrParseLoadAvg
+	}
+	var l LoadAvgData
+	for i, p := range []*float64{&l.Load1, &l.Load5, &l.Load15} {
+		v, err := strconv.ParseFloat(tokens[i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrParseLoadAvg, err)
+		}
+		*p = v
+	}
+	running, total, ok := strings.Cut(tokens[3], "/")
+	if !ok {
+		return nil, ErrParseLoadAvg
+	}
+	var err error
+	if l.Running, err = strconv.Atoi(running); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrParseLoadAvg, err)
+	}
+	if l.Total, err = strconv.Atoi(total); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrParseLoadAvg, err)
+	}
+	return &l, nil
+}
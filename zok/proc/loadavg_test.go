@@ -0,0 +1,43 @@
+package proc
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseLoadAvg(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want *LoadAvgData
+	}{
+		{"idle", "0.00 0.01 0.05 1/120 4321\n", &LoadAvgData{0, 0.01, 0.05, 1, 120}},
+		{"busy", "12.52 8.58 4.59 17/1467 982345\n", &LoadAvgData{12.52, 8.58, 4.59, 17, 1467}},
+		{"no newline", "0.52 0.58 0.59 1/467 12345", &LoadAvgData{0.52, 0.58, 0.59, 1, 467}},
+		{"without last pid", "0.52 0.58 0.59 1/467", &LoadAvgData{0.52, 0.58, 0.59, 1, 467}},
+		{"empty", "", nil},
+		{"too few fields", "0.52 0.58 0.59\n", nil},
+		{"bad load", "0.52 high 0.59 1/467 12345\n", nil},
+		{"no slash", "0.52 0.58 0.59 467 12345\n", nil},
+		{"bad running", "0.52 0.58 0.59 x/467 12345\n", nil},
+		{"bad total", "0.52 0.58 0.59 1/ 12345\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLoadAvg(strings.NewReader(tt.in))
+			if tt.want == nil {
+				if !errors.Is(err, ErrParseLoadAvg) {
+					t.Fatalf("err = %v, want ErrParseLoadAvg", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != *tt.want {
+				t.Errorf("got %+v, want %+v", *got, *tt.want)
+			}
+		})
+	}
+}
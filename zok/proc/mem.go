@@ -9,9 +9,9 @@ import (
 )
 
 type Memmoryinfo struct {
-	MemTotal     uint64
-	MemFree      uint64
-	MemAvailable uint64
+	MemTotal     uint64 `json:"mem_total_kb"`
+	MemFree      uint64 `json:"mem_free_kb"`
+	MemAvailable uint64 `json:"mem_available_kb"`
 }
 
 var ErrParseProcMemInfo = errors.New("parse /proc/meminfo error")
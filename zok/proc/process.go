@@ -67,11 +67,11 @@ func FindProcess(nameOrPID string) (int, []byte, bool) {
 }
 
 type ProcStatus struct {
-	Name   string
-	Pid    int
-	VMPeak uint64 // Peak virtual memory size(KB)
-	VMSize uint64 // Virtual memory size(KB)
-	VMRss  uint64 // Resident set size(KB)
+	Name   string `json:"name"`
+	Pid    int    `json:"pid"`
+	VMPeak uint64 `json:"vm_peak_kb"` // Peak virtual memory size(KB)
+	VMSize uint64 `json:"vm_size_kb"` // Virtual memory size(KB)
+	VMRss  uint64 `json:"vm_rss_kb"`  // Resident set size(KB)
 }
 
 func SelfStatus() (*ProcStatus, error) {
@@ -12,8 +12,8 @@ import (
 var ErrParseUptime = errors.New("parse /proc/uptime error")
 
 type UptimeData struct {
-	Uptime float64 // second
-	Idle   float64 // second
+	Uptime float64 `json:"uptime_seconds"` // second
+	Idle   float64 `json:"idle_seconds"`   // second
 }
 
 func Uptime() (*UptimeData, error) {